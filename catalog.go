@@ -0,0 +1,281 @@
+package virtualfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidRoot = fmt.Errorf("invalid root name")
+
+const catalogDB = "catalog.db"
+
+// Catalog is storage shared by many root Fs so a file found in many scans is only stored once.
+// Files are stored in `blobs` and each root has its own fin.db in `roots/<root>`.
+// The catalog keeps track of which roots use a file so it can be removed once no root uses it
+// NOTE: a catalog should only be used by one process at a time
+type Catalog struct {
+	dir     string
+	opts    *options
+	store   blobStore
+	dbStore blobStore
+	mu      sync.Mutex
+	blobs   map[string]*catalogBlob
+}
+
+// catalogBlob is a stored file and the roots using it
+type catalogBlob struct {
+	Sha512 string          `json:"sha512"`
+	Id     string          `json:"id"`
+	Roots  map[string]bool `json:"roots"`
+}
+
+// NewCatalog opens (creating if needed) a catalog in dir
+func NewCatalog(dir string, opts ...Option) (*Catalog, error) {
+	o := newOptions(opts)
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create blobs dir: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "roots"), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create roots dir: %w", err)
+	}
+
+	store, err := newStore(newDirStore(filepath.Join(dir, "blobs")), o)
+	if err != nil {
+		return nil, err
+	}
+	dbStore, err := newStore(newDirStore(dir), o)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{dir: dir, opts: o, store: store, dbStore: dbStore, blobs: make(map[string]*catalogBlob)}
+	if err := c.load(); err != nil {
+		return c, err
+	}
+	// so removing files frees the chunks only they use
+	ids := make([]string, 0, len(c.blobs))
+	for _, blob := range c.blobs {
+		ids = append(ids, blob.Id)
+	}
+	return c, trackChunks(store, ids)
+}
+
+// NewFs creates a new root in the catalog (see NewFs)
+func (c *Catalog) NewFs(root, name string, mode os.FileMode, modTime time.Time, r io.Reader) (*Fs, error) {
+	db, err := c.newReferenceDB(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Mkdir(db.dbDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create root dir: %w", err)
+	}
+
+	return newRootFs(db, name, mode, modTime, r)
+}
+
+// Open loads a root from the catalog (see NewFsFromDb)
+func (c *Catalog) Open(root string) (*Fs, error) {
+	db, err := c.newReferenceDB(root)
+	if err != nil {
+		return nil, err
+	}
+	return loadRootFs(db)
+}
+
+// Roots returns the names of the roots in the catalog
+func (c *Catalog) Roots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(c.dir, "roots"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading roots - %w", err)
+	}
+
+	roots := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			roots = append(roots, e.Name())
+		}
+	}
+	return roots, nil
+}
+
+// Delete removes a root and any files no other root uses
+// NOTE: the root shouldnt be open
+func (c *Catalog) Delete(root string) error {
+	if err := validRoot(root); err != nil {
+		return err
+	}
+	rootDir := filepath.Join(c.dir, "roots", root)
+	if _, err := os.Stat(rootDir); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotFound, root)
+	}
+
+	c.mu.Lock()
+	for sha512 := range c.blobs {
+		if err := c.releaseLocked(root, sha512); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	c.mu.Unlock()
+
+	if err := os.RemoveAll(rootDir); err != nil {
+		return fmt.Errorf("error removing root %v - %w", root, err)
+	}
+	return c.save()
+}
+
+// RefCount returns the number of roots using the file with the sha512
+func (c *Catalog) RefCount(sha512 string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if blob, ok := c.blobs[sha512]; ok {
+		return len(blob.Roots)
+	}
+	return 0
+}
+
+// ----------------Helpers--------------------
+func validRoot(root string) error {
+	if root == "" || root == "." || root == ".." || strings.ContainsAny(root, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidRoot, root)
+	}
+	return nil
+}
+
+// newReferenceDB creates a reference db for the root that stores files in the catalog
+func (c *Catalog) newReferenceDB(root string) (*referenceDB, error) {
+	if err := validRoot(root); err != nil {
+		return nil, err
+	}
+
+	dbDir := filepath.Join(c.dir, "roots", root)
+	dbStore, err := newStore(newDirStore(dbDir), c.opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMetadataOptions(c.opts); err != nil {
+		return nil, err
+	}
+	storage := newDBStorage(c.opts)
+	storage.Catalog = true
+	return &referenceDB{
+		storageDir:   filepath.Join(c.dir, "blobs"),
+		store:        c.store,
+		dbDir:        dbDir,
+		dbStore:      dbStore,
+		catalog:      c,
+		root:         root,
+		journalEvery: c.opts.journal,
+		storage:      storage,
+		refMap:       make(map[string]*reference),
+		blobs:        make(map[string]int),
+	}, nil
+}
+
+// claim marks the file with sha512 as used by root, returning the id it is stored as
+// (the id passed if this is the first time the catalog has seen the file)
+func (c *Catalog) claim(root, sha512, id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blob, ok := c.blobs[sha512]
+	if !ok {
+		blob = &catalogBlob{Sha512: sha512, Id: id, Roots: make(map[string]bool)}
+		c.blobs[sha512] = blob
+	}
+	blob.Roots[root] = true
+	return blob.Id
+}
+
+// release marks the file with sha512 as no longer used by root, removing it if no root uses it
+func (c *Catalog) release(root, sha512 string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.releaseLocked(root, sha512)
+}
+
+func (c *Catalog) releaseLocked(root, sha512 string) error {
+	blob, ok := c.blobs[sha512]
+	if !ok || !blob.Roots[root] {
+		return nil
+	}
+	delete(blob.Roots, root)
+	if len(blob.Roots) > 0 {
+		return nil
+	}
+	delete(c.blobs, sha512)
+	if err := c.store.remove(blob.Id); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing %v - %w", blob.Id, err)
+	}
+	return nil
+}
+
+// save writes the catalog db
+func (c *Catalog) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, err := c.dbStore.create(catalogDB)
+	if err != nil {
+		return fmt.Errorf("error opening catalog db - %w", err)
+	}
+
+	shas := make([]string, 0, len(c.blobs))
+	for sha512 := range c.blobs {
+		shas = append(shas, sha512)
+	}
+	slices.Sort(shas)
+	for _, sha512 := range shas {
+		jsonString, err := json.Marshal(c.blobs[sha512])
+		if err != nil {
+			file.Delete()
+			return fmt.Errorf("error marshalling catalog blob %v - %w", sha512, err)
+		}
+		if _, err := file.Write(append(jsonString, '\n')); err != nil {
+			file.Delete()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// load reads the catalog db if it exists
+func (c *Catalog) load() error {
+	file, err := c.dbStore.open(catalogDB)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening catalog db - %w", err)
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	// blobs used by a lot of roots can have long lines
+	sc.Buffer(nil, 64*1024*1024)
+	for sc.Scan() {
+		blob := &catalogBlob{}
+		if err := json.Unmarshal(sc.Bytes(), blob); err != nil {
+			return fmt.Errorf("error unmarshalling catalog blob - %w", err)
+		}
+		c.blobs[blob.Sha512] = blob
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("error reading catalog db - %w", err)
+	}
+	return nil
+}
+
+// ----------------Helpers--------------------
@@ -0,0 +1,83 @@
+package virtualfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		c, err := NewCatalog(tmp)
+		fatalfIfErr(t, err, "failed to create catalog")
+		blobs := filepath.Join(tmp, "blobs")
+
+		//------------ Two roots with the same file
+		f, err := os.Open(fooFile)
+		fatalfIfErr(t, err, "failed to open foo file")
+		defer f.Close()
+		scan1, err := c.NewFs("scan1", "foo", fooMod, fooTime, f)
+		fatalfIfErr(t, err, "failed to create scan1")
+		err = createFile(scan1, "/libc.so", 0755, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /libc.so in scan1")
+		fatalfIfErr(t, scan1.Close(), "failed to close scan1")
+		assertTmpDirFileCount(t, 2, blobs, "after scan1")
+
+		scan2, err := c.NewFs("scan2", "foo-folder", testMod, testTime, nil)
+		fatalfIfErr(t, err, "failed to create scan2")
+		err = createFile(scan2, "/lib/libc.so", 0755, time2, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /lib/libc.so in scan2")
+		err = createFile(scan2, "/lib/other.so", 0755, time2, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /lib/other.so in scan2")
+		fatalfIfErr(t, scan2.Close(), "failed to close scan2")
+		assertTmpDirFileCount(t, 3, blobs, "libc.so should only be stored once")
+
+		_, err = c.NewFs("scan2", "foo-folder", testMod, testTime, nil)
+		assert(t, err != nil, "should error creating a root that already exists")
+		_, err = c.NewFs("../scan3", "foo-folder", testMod, testTime, nil)
+		assertErr(t, ErrInvalidRoot, err, "should error on root outside catalog")
+
+		assertEqual(t, 2, c.RefCount(helloWorldSha512), "libc.so should be used by both roots")
+		assertEqual(t, 1, c.RefCount(helloFooSha512), "other.so should be used by one root")
+
+		//------------ Reopen catalog
+		c, err = NewCatalog(tmp)
+		fatalfIfErr(t, err, "failed to reopen catalog")
+		roots, err := c.Roots()
+		fatalfIfErr(t, err, "failed to list roots")
+		assertEqual(t, 2, len(roots), "expected two roots")
+		assertEqual(t, "scan1", roots[0], "expected scan1")
+		assertEqual(t, "scan2", roots[1], "expected scan2")
+		assertEqual(t, 2, c.RefCount(helloWorldSha512), "libc.so should be used by both roots after reopening")
+
+		scan1, err = c.Open("scan1")
+		fatalfIfErr(t, err, "failed to open scan1")
+		assertContent(t, "Hello, World!", scan1, "/libc.so")
+
+		//------------ Remove file from a root
+		scan2, err = c.Open("scan2")
+		fatalfIfErr(t, err, "failed to open scan2")
+		fatalfIfErr(t, scan2.Remove("/lib/other.so"), "failed to remove other.so")
+		fatalfIfErr(t, scan2.Remove("/lib/libc.so"), "failed to remove libc.so")
+		fatalfIfErr(t, scan2.Close(), "failed to close scan2")
+		assertTmpDirFileCount(t, 2, blobs, "other.so should be removed")
+		assertEqual(t, 0, c.RefCount(helloFooSha512), "other.so shouldnt be used")
+		assertEqual(t, 1, c.RefCount(helloWorldSha512), "libc.so should still be used by scan1")
+
+		//------------ Delete roots
+		err = c.Delete("scan2")
+		fatalfIfErr(t, err, "failed to delete scan2")
+		assertTmpDirFileCount(t, 2, blobs, "should only remove files just used by scan2")
+		assertContent(t, "Hello, World!", scan1, "/libc.so")
+
+		err = c.Delete("scan2")
+		assertErr(t, ErrNotFound, err, "should error deleting root that doesnt exist")
+
+		err = c.Delete("scan1")
+		fatalfIfErr(t, err, "failed to delete scan1")
+		assertTmpDirFileCount(t, 0, blobs, "should remove all files")
+		roots, err = c.Roots()
+		fatalfIfErr(t, err, "failed to list roots")
+		assertEqual(t, 0, len(roots), "expected no roots")
+	})
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package virtualfs
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// deviceNumbers returns the major and minor device numbers of the file on disk
+func deviceNumbers(info fs.FileInfo) (int64, int64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	dev := uint64(stat.Rdev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return int64(major), int64(minor)
+}
+
+// mknod creates a device, fifo or socket on disk (usually only root can create devices)
+func mknod(path string, mode os.FileMode, major, minor int64) error {
+	var typ uint32
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		typ = syscall.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		typ = syscall.S_IFBLK
+	case mode&fs.ModeNamedPipe != 0:
+		typ = syscall.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		typ = syscall.S_IFSOCK
+	}
+	dev := uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+	return syscall.Mknod(path, typ|0600, int(dev))
+}
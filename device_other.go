@@ -0,0 +1,19 @@
+//go:build !linux
+
+package virtualfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// deviceNumbers arent known so always 0
+func deviceNumbers(info fs.FileInfo) (int64, int64) {
+	return 0, 0
+}
+
+// mknod isnt supported so they are skipped
+func mknod(path string, mode os.FileMode, major, minor int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,228 @@
+package virtualfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrDiagnosticCodeRegistered the code or error is already registered (see RegisterDiagnosticCode)
+var ErrDiagnosticCodeRegistered = fmt.Errorf("diagnostic code already registered")
+
+// Severity is if a Diagnostic is an error or warning
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is an error or warning on a file (see Fs.Error and Fs.Warning). Code is saved so
+// errors.Is matches the error registered with it (see RegisterDiagnosticCode) after loading
+type Diagnostic struct {
+	Code     string
+	Severity Severity
+	Message  string
+	// Extractor is what found it (i.e. the name of the unpacker)
+	Extractor string
+	// Offset in the file it applies to, -1 if it isnt known
+	Offset int64
+	Time   time.Time
+	// Cause is the error it wraps, not saved (only Code and Message are)
+	Cause error
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Message == "" && d.Cause != nil {
+		return d.Cause.Error()
+	}
+	return d.Message
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Cause
+}
+
+// Is matches the error registered with Code so loaded diagnostics (without a Cause) still match
+func (d *Diagnostic) Is(target error) bool {
+	if d.Code == "" {
+		return false
+	}
+	registered := diagnosticCodes.get(d.Code)
+	return registered != nil && registered == target
+}
+
+// newDiagnostic returns err as a Diagnostic with severity, a copy if its already one (filling in
+// what isnt set) otherwise with the code of the first error registered it matches
+func newDiagnostic(severity Severity, err error) *Diagnostic {
+	d := &Diagnostic{Message: err.Error(), Offset: -1, Cause: err}
+	var existing *Diagnostic
+	if errors.As(err, &existing) {
+		copied := *existing
+		d = &copied
+		// keep the context its wrapped in
+		if error(existing) != err {
+			d.Message, d.Cause = err.Error(), err
+		}
+	}
+	d.Severity = severity
+	if d.Code == "" {
+		d.Code = diagnosticCodes.codeOf(d.Cause)
+	}
+	if d.Time.IsZero() {
+		d.Time = time.Now().UTC()
+	}
+	return d
+}
+
+// ------------- codes ------------------
+type diagnosticCode struct {
+	code string
+	err  error
+}
+
+// diagnosticCodes are the errors that can be matched (see Diagnostic.Is) after loading in the
+// order they were registered
+var diagnosticCodes = &diagnosticRegistry{codes: []diagnosticCode{
+	{"not_found", ErrNotFound},
+	{"outside_filesystem", ErrOutsideFilesystem},
+	{"out_of_range", ErrOutOfRange},
+	{"unsupported_type", ErrUnsupportedType},
+	{"circular_reference", ErrCircularReference},
+	{"corrupt_db", ErrCorruptDB},
+	{"unsupported_format", ErrUnsupportedFormat},
+	{"corrupt_journal", ErrCorruptJournal},
+	{"memory_limit", ErrMemoryLimit},
+	{"decrypt", ErrDecrypt},
+	{"unsafe_symlink", ErrUnsafeSymlink},
+	{"unsafe_name", ErrUnsafeName},
+}}
+
+type diagnosticRegistry struct {
+	mu    sync.RWMutex
+	codes []diagnosticCode
+}
+
+// RegisterDiagnosticCode saves errors (passed to Fs.Error and Fs.Warning) that match err with code
+// so errors.Is matches err after loading. Registering the same code and error again does nothing
+func RegisterDiagnosticCode(code string, err error) error {
+	if code == "" || err == nil || !reflect.TypeOf(err).Comparable() {
+		return fmt.Errorf("%w: code and a comparable error are required", ErrDiagnosticCodeRegistered)
+	}
+	diagnosticCodes.mu.Lock()
+	defer diagnosticCodes.mu.Unlock()
+
+	i := slices.IndexFunc(diagnosticCodes.codes, func(c diagnosticCode) bool {
+		return c.code == code || c.err == err
+	})
+	if i >= 0 {
+		if c := diagnosticCodes.codes[i]; c.code == code && c.err == err {
+			return nil
+		}
+		return fmt.Errorf("%w: %v (%v)", ErrDiagnosticCodeRegistered, code, err)
+	}
+	diagnosticCodes.codes = append(diagnosticCodes.codes, diagnosticCode{code, err})
+	return nil
+}
+
+// get returns the error registered with code, nil if its unknown (i.e. registered by another program)
+func (r *diagnosticRegistry) get(code string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// codeOf returns the code of the first error registered that err matches, blank if none
+func (r *diagnosticRegistry) codeOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// ------------- codes ------------------
+
+// jsonDiagnostic is a Diagnostic as its saved (without the Cause)
+type jsonDiagnostic struct {
+	Code      string    `json:"code,omitempty"`
+	Severity  Severity  `json:"severity"`
+	Message   string    `json:"message"`
+	Extractor string    `json:"extractor,omitempty"`
+	Offset    int64     `json:"offset"`
+	Time      time.Time `json:"time"`
+}
+
+func toJsonDiagnostic(d *Diagnostic) jsonDiagnostic {
+	return jsonDiagnostic{
+		Code:      d.Code,
+		Severity:  d.Severity,
+		Message:   d.Error(),
+		Extractor: d.Extractor,
+		Offset:    d.Offset,
+		Time:      d.Time,
+	}
+}
+
+func (data jsonDiagnostic) diagnostic() *Diagnostic {
+	return &Diagnostic{
+		Code:      data.Code,
+		Severity:  data.Severity,
+		Message:   data.Message,
+		Extractor: data.Extractor,
+		Offset:    data.Offset,
+		Time:      data.Time,
+	}
+}
+
+// messageDiagnostics saves the error and warnings of a format 1 jsonFs as diagnostics
+// (see jsonDiagnostic and migrateBaseline)
+func messageDiagnostics(line []byte) ([]byte, error) {
+	entry := map[string]json.RawMessage{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	var message string
+	var warnings []string
+	if raw, ok := entry["error"]; ok {
+		if err := json.Unmarshal(raw, &message); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := entry["warning"]; ok {
+		if err := json.Unmarshal(raw, &warnings); err != nil {
+			return nil, err
+		}
+	}
+	delete(entry, "error")
+	delete(entry, "warning")
+	diagnostics := []jsonDiagnostic{}
+	if message != "" {
+		diagnostics = append(diagnostics, jsonDiagnostic{Severity: SeverityError, Message: message, Offset: -1})
+	}
+	for _, warning := range warnings {
+		diagnostics = append(diagnostics, jsonDiagnostic{Severity: SeverityWarning, Message: warning, Offset: -1})
+	}
+	if len(diagnostics) > 0 {
+		var err error
+		if entry["diagnostics"], err = json.Marshal(diagnostics); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(entry)
+}
@@ -0,0 +1,137 @@
+package virtualfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errTestBadHeader = fmt.Errorf("bad header")
+
+func init() {
+	if err := RegisterDiagnosticCode("virtualfs.bad_header", errTestBadHeader); err != nil {
+		panic(err)
+	}
+}
+
+// addDiagnostics adds an error matching a package error, a diagnostic with a registered code and a
+// plain warning to /foo/bar
+func addDiagnostics(t *testing.T, v *Fs) {
+	t.Helper()
+	err := createFile(v, "/foo/bar", 0644, time1, "Hello, World!")
+	fatalfIfErr(t, err, "failed to create /foo/bar")
+	bar, err := v.Stat("/foo/bar")
+	fatalfIfErr(t, err, "failed to stat /foo/bar")
+	bar.Error(fmt.Errorf("%w: /foo/bar/dev", ErrUnsupportedType))
+	bar.Warning(&Diagnostic{Message: "header 2 is bad", Extractor: "tar", Offset: 512, Cause: errTestBadHeader})
+	bar.Warning(fmt.Errorf("yikes"))
+}
+
+// assertDiagnostics asserts the diagnostics added by addDiagnostics
+func assertDiagnostics(t *testing.T, v *Fs, msg string) {
+	t.Helper()
+	bar, err := v.Stat("/foo/bar")
+	fatalfIfErr(t, err, "%v: failed to stat /foo/bar", msg)
+	errs, warns := bar.Errors(), bar.Warnings()
+	assertEqual(t, 1, len(errs), "%v: should have the error", msg)
+	assertEqual(t, 2, len(warns), "%v: should have the warnings", msg)
+
+	assertErr(t, ErrUnsupportedType, errs[0], "%v: error should match", msg)
+	assertEqual(t, "unsupported_type", errs[0].Code, "%v: should have the code", msg)
+	assertEqual(t, SeverityError, errs[0].Severity, "%v: should be an error", msg)
+	assertEqual(t, "unsupported file type: /foo/bar/dev", errs[0].Error(), "%v: should have the message", msg)
+	assertEqual(t, int64(-1), errs[0].Offset, "%v: offset should be unknown", msg)
+	assert(t, !errs[0].Time.IsZero(), "%v: should have the time", msg)
+
+	assertErr(t, errTestBadHeader, warns[0], "%v: registered error should match", msg)
+	assertEqual(t, "virtualfs.bad_header", warns[0].Code, "%v: should have the registered code", msg)
+	assertEqual(t, SeverityWarning, warns[0].Severity, "%v: should be a warning", msg)
+	assertEqual(t, "tar", warns[0].Extractor, "%v: should have the extractor", msg)
+	assertEqual(t, int64(512), warns[0].Offset, "%v: should have the offset", msg)
+	assert(t, !errors.Is(warns[0], ErrUnsupportedType), "%v: shouldnt match other errors", msg)
+
+	assertEqual(t, "", warns[1].Code, "%v: unregistered error shouldnt have a code", msg)
+	assertEqual(t, "yikes", warns[1].Error(), "%v: should have the message", msg)
+}
+
+func TestDiagnostics(t *testing.T) {
+	for name, opts := range map[string][]Option{"json": nil, "compact": {WithCompactDB()}, "sqlite": {WithSQLite()}} {
+		tmpDir(t, func(tmp string) {
+			v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, opts...)
+			fatalfIfErr(t, err, "%v: failed to create virtual function", name)
+			addDiagnostics(t, v)
+			assertDiagnostics(t, v, name)
+			expected := treeLines(t, v)
+			fatalfIfErr(t, v.Close(), "%v: failed to close", name)
+
+			v, err = NewFsFromDb(tmp)
+			fatalfIfErr(t, err, "%v: failed to load", name)
+			assertDiagnostics(t, v, name+" loaded")
+			assertEqual(t, expected, treeLines(t, v), "%v: loaded tree should match", name)
+			assertErr(t, ErrInFilesystem, v.FsError(), "%v: should load errors", name)
+			assertErr(t, ErrInFilesystem, v.FsWarning(), "%v: should load warnings", name)
+		})
+	}
+}
+
+func TestDiagnosticsJournal(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		addDiagnostics(t, v)
+		expected := treeLines(t, v)
+
+		//------------ Crash (no Close) and replay
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertDiagnostics(t, v, "replayed")
+		assertEqual(t, expected, treeLines(t, v), "replayed tree should match")
+	})
+}
+
+func TestDiagnosticsOlderFormat(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		root, err := json.Marshal(toJsonFs("/", false, v))
+		fatalfIfErr(t, err, "failed to marshal root")
+		assert(t, !strings.Contains(string(root), "diagnostics"), "shouldnt save diagnostics if none")
+
+		//------------ Errors saved before diagnostics (format 1) load from the messages
+		legacy := strings.Replace(string(root), `"tags":{}`, `"tags":{},"error":"bad file","warning":["yikes"]`, 1)
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, finDB), []byte(legacy+"\n"), 0644), "failed to write format 1")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load format 1")
+		assertErr(t, ErrInFilesystem, v.FsError(), "should load errors")
+		assertEqual(t, 1, len(v.Errors()), "should have the error")
+		assertEqual(t, "bad file", v.Errors()[0].Error(), "should have the error message")
+		assertEqual(t, SeverityError, v.Errors()[0].Severity, "should be an error")
+		assertEqual(t, 1, len(v.Warnings()), "should have the warning")
+		assertEqual(t, "yikes", v.Warnings()[0].Error(), "should have the warning message")
+	})
+}
+
+func TestDiagnosticWrapped(t *testing.T) {
+	v, err := newFooMemFs()
+	fatalfIfErr(t, err, "failed to create virtual function")
+	d := &Diagnostic{Code: "virtualfs.bad_header", Message: "header 2 is bad", Extractor: "tar", Offset: 512}
+	v.Error(fmt.Errorf("extracting /foo - %w", d))
+	errs := v.Errors()
+	assertEqual(t, 1, len(errs), "should have the error")
+	assertEqual(t, "extracting /foo - header 2 is bad", errs[0].Error(), "should keep the context")
+	assertEqual(t, "tar", errs[0].Extractor, "should keep the extractor")
+	assertErr(t, errTestBadHeader, errs[0], "should match the code")
+	assertEqual(t, Severity(""), d.Severity, "shouldnt change the diagnostic passed")
+}
+
+func TestRegisterDiagnosticCode(t *testing.T) {
+	fatalfIfErr(t, RegisterDiagnosticCode("virtualfs.bad_header", errTestBadHeader), "registering again should do nothing")
+	assertErr(t, ErrDiagnosticCodeRegistered, RegisterDiagnosticCode("virtualfs.other", errTestBadHeader), "error is already registered")
+	assertErr(t, ErrDiagnosticCodeRegistered, RegisterDiagnosticCode("virtualfs.bad_header", errors.New("other")), "code is already registered")
+	assertErr(t, ErrDiagnosticCodeRegistered, RegisterDiagnosticCode("not_found", errors.New("other")), "built in codes cant be registered")
+	assertErr(t, ErrDiagnosticCodeRegistered, RegisterDiagnosticCode("", errors.New("other")), "code is required")
+}
@@ -11,3 +11,18 @@ var ErrDontWalk = fmt.Errorf("dont walk entries children")
 var ErrNotFound = fmt.Errorf("file not found") // https://smyrman.medium.com/writing-constant-errors-with-go-1-13-10c4191617
 var ErrOutsideFilesystem = fmt.Errorf("path is outside of filesystem")
 var ErrInFilesystem = fmt.Errorf("filesystem errors")
+var ErrOutOfRange = fmt.Errorf("out of range")
+var ErrUnsupportedType = fmt.Errorf("unsupported file type")
+var ErrNotEmpty = fmt.Errorf("directory not empty")
+var ErrFileExists = fmt.Errorf("file already exists")
+var ErrNotDir = fmt.Errorf("not a directory")
+var ErrMoveIntoItself = fmt.Errorf("cannot move a directory into itself")
+var ErrNoXattr = fmt.Errorf("extended attribute not found")
+var ErrCorruptDB = fmt.Errorf("db is corrupt")
+var ErrUnsupportedFormat = fmt.Errorf("unsupported db format")
+
+// ErrStorageMismatch the options dont match the storage layout the db was saved with (see DBStorage)
+var ErrStorageMismatch = fmt.Errorf("storage options dont match the db")
+
+// ErrNotOnDisk the file isnt stored as a file on disk (see Fs.OpenFileReader)
+var ErrNotOnDisk = fmt.Errorf("file isnt stored as a file on disk")
@@ -0,0 +1,385 @@
+package virtualfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jonathongardner/fifo/filetype"
+)
+
+var ErrUnsafeSymlink = fmt.Errorf("symlink points outside of export")
+var ErrUnsafeName = fmt.Errorf("unsafe file name")
+
+// LinkMode is how ExportDir creates files that are in the storage dir
+type LinkMode int
+
+const (
+	// LinkCopy copies the contents of the file
+	LinkCopy LinkMode = iota
+	// LinkHardlink hardlinks to the file in the storage dir, falls back to copy if not possible.
+	// NOTE: mode and times are not set since that would change the file in the storage dir
+	LinkHardlink
+	// LinkReflink reflinks (copy on write) the file in the storage dir, falls back to copy if not possible
+	LinkReflink
+)
+
+// ExportOption configures exporting (see ExportDir, ExportTar and ExportZip)
+type ExportOption func(*exportOptions)
+
+type exportOptions struct {
+	at             int
+	original       bool
+	link           LinkMode
+	unsafeSymlinks bool
+	compression    archiveCompression
+}
+
+func newExportOptions(opts []ExportOption) *exportOptions {
+	o := &exportOptions{at: -1, link: LinkCopy}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ExportAt exports the layer at for the path (see StatAt), default is the last layer
+func ExportAt(at int) ExportOption {
+	return func(o *exportOptions) {
+		o.at = at
+	}
+}
+
+// ExportOriginal exports the original file for files that have a child (i.e. foo.tar.gz)
+// instead of the last layer (the contents of foo.tar.gz)
+func ExportOriginal() ExportOption {
+	return func(o *exportOptions) {
+		o.original = true
+	}
+}
+
+// ExportLinks sets how files are created from the storage dir (see LinkMode)
+func ExportLinks(mode LinkMode) ExportOption {
+	return func(o *exportOptions) {
+		o.link = mode
+	}
+}
+
+// ExportUnsafeSymlinks writes symlinks as is, by default absolute symlinks are made relative
+// to the export and symlinks that point outside the export return ErrUnsafeSymlink
+func ExportUnsafeSymlinks() ExportOption {
+	return func(o *exportOptions) {
+		o.unsafeSymlinks = true
+	}
+}
+
+// ExportDir writes the path (dir or file) to dest on disk, recreating dirs, symlinks, hardlinks
+// (see Hardlink), devices, fifos and sockets (if permitted), owners (if permitted),
+// extended attributes (if permitted), modes and times. dest must not already exist. Nothing the export creates is ever written through a symlink so a malicious tree cant write outside of dest
+func (v *Fs) ExportDir(path, dest string, opts ...ExportOption) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	o := newExportOptions(opts)
+	top, path, err := v.fsFrom(path, o.at)
+	if err != nil {
+		return fmt.Errorf("%w: %v (export)", err, path)
+	}
+
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	// symlinks above dest are the callers (i.e. /tmp on macOS) so resolve them once
+	dir, err := filepath.EvalSymlinks(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	dest = filepath.Join(dir, filepath.Base(dest))
+	e := &dirExporter{root: dest, opts: o, top: exportLayer(top, o, o.at >= 0), links: make(map[string]string)}
+	if err := exportWalk("/", top, o.at >= 0, o, e.export); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+// exportLayer returns the layer of n to export, the last layer unless original
+// is set. asIs means n was asked for specifically (see ExportAt)
+func exportLayer(n *Fs, o *exportOptions, asIs bool) *Fs {
+	if asIs || o.original {
+		return n
+	}
+	for n.ref.child != nil {
+		n = n.ref.child
+	}
+	return n
+}
+
+// exportIsDir returns true if the node should be exported as a directory
+// (files that have children are exported as directories)
+func exportIsDir(n *Fs) bool {
+	return n.IsDir() || (n.ref.child == nil && len(n.ref.children) > 0)
+}
+
+// exportDirMode returns the mode to export a directory (see exportIsDir) with, files
+// with children get 0700 so their children can be read
+func exportDirMode(n *Fs) fs.FileMode {
+	if n.IsDir() {
+		return n.mode
+	}
+	return n.mode | 0700
+}
+
+// exportWalk calls the callback for the layer of n to export and then its children (sorted)
+// path is relative to the export (the top is "/"). asIs means n was asked for specifically (see ExportAt)
+func exportWalk(path string, n *Fs, asIs bool, o *exportOptions, callback func(string, *Fs) error) error {
+	n = exportLayer(n, o, asIs)
+	if err := callback(path, n); err != nil {
+		return err
+	}
+	if !exportIsDir(n) {
+		return nil
+	}
+
+	names := make([]string, 0, len(n.ref.children))
+	for name := range n.ref.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := validName(name); err != nil {
+			return err
+		}
+		if err := exportWalk(filepath.Join(path, name), n.ref.children[name], false, o, callback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validName makes sure a name (from fin.db) cant be used to escape
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrUnsafeName, name)
+	}
+	return nil
+}
+
+// maxSymlinks is how many symlinks are followed resolving a target (see dirExporter.resolve)
+const maxSymlinks = 255
+
+// symlinkTarget returns the symlink target to write for the symlink at path (relative to the export)
+func (e *dirExporter) symlinkTarget(path, target string) (string, error) {
+	if e.opts.unsafeSymlinks {
+		return target, nil
+	}
+	if err := e.resolve(filepath.Dir(path), target); err != nil {
+		return "", fmt.Errorf("%w: %v -> %v", err, path, target)
+	}
+	if !filepath.IsAbs(target) {
+		return target, nil
+	}
+	// make absolute symlinks relative to the export
+	return filepath.Rel(filepath.Dir(path), filepath.Join("/", target))
+}
+
+// resolve follows target from dir through the symlinks in the export (like securejoin) returning
+// ErrUnsafeSymlink if it goes above the root, absolute targets are relative to the export
+func (e *dirExporter) resolve(dir, target string) error {
+	// dirs resolved so far, nil if not in the export
+	resolved := []*Fs{}
+	remaining := strings.Split(target, "/")
+	if !filepath.IsAbs(target) {
+		remaining = append(strings.Split(dir, "/"), remaining...)
+	}
+	links := 0
+	for len(remaining) > 0 {
+		name := remaining[0]
+		remaining = remaining[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return ErrUnsafeSymlink
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		parent := e.top
+		if len(resolved) > 0 {
+			parent = resolved[len(resolved)-1]
+		}
+		var n *Fs
+		if parent != nil && exportIsDir(parent) {
+			if child, ok := parent.ref.children[name]; ok {
+				n = exportLayer(child, e.opts, false)
+			}
+		}
+		if n == nil || n.ref.typ != filetype.Symlink {
+			resolved = append(resolved, n)
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return fmt.Errorf("%w: too many levels of symlinks", ErrUnsafeSymlink)
+		}
+		if filepath.IsAbs(n.symlinkPath) {
+			resolved = resolved[:0]
+		}
+		remaining = append(strings.Split(n.symlinkPath, "/"), remaining...)
+	}
+	return nil
+}
+
+// ------------- dirExporter ------------------
+type dirExporter struct {
+	root string
+	// top is the layer exported at the root (see resolve)
+	top  *Fs
+	opts *exportOptions
+	// first path exported for each link (see Hardlink)
+	links map[string]string
+	dirs  []*exportedDir
+}
+
+// exportedDir is a dir whose mode and times are set once everything is written
+type exportedDir struct {
+	path string
+	node *Fs
+}
+
+func (e *dirExporter) export(path string, n *Fs) error {
+	dest := filepath.Join(e.root, path)
+
+	// make sure we never write through a symlink (the parent of the root was resolved in ExportDir)
+	if path != "/" {
+		parent, err := os.Lstat(filepath.Dir(dest))
+		if err != nil {
+			return err
+		}
+		if !parent.IsDir() {
+			return fmt.Errorf("%w: parent of %v is not a dir", ErrUnsafeName, dest)
+		}
+	}
+
+	switch {
+	case n.ref.typ == filetype.Symlink:
+		target, err := e.symlinkTarget(path, n.symlinkPath)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	case exportIsDir(n):
+		if err := os.Mkdir(dest, 0700); err != nil {
+			return err
+		}
+		e.dirs = append(e.dirs, &exportedDir{path: dest, node: n})
+		return nil
+	case n.SpecialType():
+		// usually only root can create devices so skip if not permitted
+		err := mknod(dest, n.mode, n.devMajor, n.devMinor)
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return setModeAndTime(dest, n)
+	default:
+		return e.exportFile(dest, n)
+	}
+}
+
+func (e *dirExporter) exportFile(dest string, n *Fs) error {
+	// hardlinked to a file already exported, files with the same contents that arent
+	// hardlinked (see Hardlink) are copied so they keep their own mode, owner and times
+	if n.link != "" {
+		if first, ok := e.links[n.link]; ok {
+			return os.Link(first, dest)
+		}
+		e.links[n.link] = dest
+	}
+
+	_, plain := n.db.store.(*dirStore)
+	if plain && n.FilePath() != "" {
+		switch e.opts.link {
+		case LinkHardlink:
+			if err := os.Link(n.FilePath(), dest); err == nil {
+				return nil
+			}
+		case LinkReflink:
+			if err := reflink(n.FilePath(), dest); err == nil {
+				return setModeAndTime(dest, n)
+			}
+		}
+	}
+
+	src, err := n.OpenFileReader()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return setModeAndTime(dest, n)
+}
+
+// finish sets the mode and times of dirs (deepest first) now that everything is written
+func (e *dirExporter) finish() error {
+	for _, dir := range slices.Backward(e.dirs) {
+		if err := setModeAndTimeTo(dir.path, exportDirMode(dir.node), dir.node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------- dirExporter ------------------
+
+func setModeAndTime(path string, n *Fs) error {
+	return setModeAndTimeTo(path, n.mode, n)
+}
+
+func setModeAndTimeTo(path string, mode fs.FileMode, n *Fs) error {
+	// chown clears setuid and capabilities so do it first, only root can usually change the owner
+	if err := os.Lchown(path, n.uid, n.gid); err != nil && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err := writeXattrs(path, n.xattrs); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	if n.modTime.IsZero() {
+		return nil
+	}
+	atime := n.times.Access
+	if atime.IsZero() {
+		atime = n.modTime
+	}
+	if err := os.Chtimes(path, atime, n.modTime); err != nil && !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package virtualfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/jonathongardner/fifo/filetype"
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveCompression is how ExportTar compresses the tar
+type archiveCompression int
+
+const (
+	archiveNone archiveCompression = iota
+	archiveGzip
+	archiveZstd
+)
+
+// ExportGzip gzips the tar (see ExportTar)
+func ExportGzip() ExportOption {
+	return func(o *exportOptions) {
+		o.compression = archiveGzip
+	}
+}
+
+// ExportZstd zstd compresses the tar (see ExportTar)
+func ExportZstd() ExportOption {
+	return func(o *exportOptions) {
+		o.compression = archiveZstd
+	}
+}
+
+// ExportTar writes the path (dir or file) as a tar to w, including symlinks, hardlinks (see
+// Hardlink), devices, fifos, owners, modes, times and extended attributes (as PAX
+// SCHILY.xattr records, sockets are skipped). Long names use PAX headers. If the path is a dir
+// its contents are at the top of the tar. Use ExportGzip or ExportZstd to compress the tar
+func (v *Fs) ExportTar(path string, w io.Writer, opts ...ExportOption) (err error) {
+	err = v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	o := newExportOptions(opts)
+	top, path, err := v.fsFrom(path, o.at)
+	if err != nil {
+		return fmt.Errorf("%w: %v (export)", err, path)
+	}
+
+	var cw io.WriteCloser
+	switch o.compression {
+	case archiveGzip:
+		cw = gzip.NewWriter(w)
+	case archiveZstd:
+		cw, err = zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		w = cw
+		// close even if the tar fails so the compressor is freed
+		defer func() {
+			if closeErr := cw.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	tw := tar.NewWriter(w)
+	// first name written for each link (see Hardlink)
+	links := make(map[string]string)
+	err = exportWalk("/", top, o.at >= 0, o, func(path string, n *Fs) error {
+		name := archiveName(path, n)
+		if name == "" {
+			return nil
+		}
+		hdr := &tar.Header{
+			Name:       name,
+			Mode:       archiveMode(n.mode),
+			ModTime:    n.modTime,
+			AccessTime: n.times.Access,
+			ChangeTime: n.times.Change,
+			Uid:        n.uid,
+			Gid:        n.gid,
+			Uname:      n.uname,
+			Gname:      n.gname,
+		}
+		// only PAX has access and change times and xattrs
+		if !hdr.AccessTime.IsZero() || !hdr.ChangeTime.IsZero() || len(n.xattrs) > 0 {
+			hdr.Format = tar.FormatPAX
+		}
+		if len(n.xattrs) > 0 {
+			hdr.PAXRecords = make(map[string]string)
+			for name, value := range n.xattrs {
+				hdr.PAXRecords[paxXattrPrefix+name] = string(value)
+			}
+		}
+
+		switch {
+		case n.ref.typ == filetype.Symlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = n.symlinkPath
+			return tw.WriteHeader(hdr)
+		case exportIsDir(n):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name = name + "/"
+			hdr.Mode = archiveMode(exportDirMode(n))
+			return tw.WriteHeader(hdr)
+		case n.SpecialType():
+			switch n.ref.typ {
+			case CharDeviceType:
+				hdr.Typeflag = tar.TypeChar
+			case BlockDeviceType:
+				hdr.Typeflag = tar.TypeBlock
+			case FifoType:
+				hdr.Typeflag = tar.TypeFifo
+			default:
+				// tar cant have sockets
+				return nil
+			}
+			hdr.Devmajor, hdr.Devminor = n.devMajor, n.devMinor
+			return tw.WriteHeader(hdr)
+		}
+
+		// files with the same contents that arent hardlinked are written again with their own header
+		if n.link != "" {
+			if first, ok := links[n.link]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				return tw.WriteHeader(hdr)
+			}
+			links[n.link] = name
+		}
+
+		file, err := n.OpenFileReader()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = size
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ExportZip writes the path (dir or file) as a zip to w, including symlinks, modes and
+// mod times (devices, fifos and sockets are skipped). Zip doesnt support hardlinks so they are written again.
+// If the path is a dir its contents are at the top of the zip
+func (v *Fs) ExportZip(path string, w io.Writer, opts ...ExportOption) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	o := newExportOptions(opts)
+	top, path, err := v.fsFrom(path, o.at)
+	if err != nil {
+		return fmt.Errorf("%w: %v (export)", err, path)
+	}
+
+	zw := zip.NewWriter(w)
+	err = exportWalk("/", top, o.at >= 0, o, func(path string, n *Fs) error {
+		name := archiveName(path, n)
+		if name == "" {
+			return nil
+		}
+		hdr := &zip.FileHeader{Name: name, Modified: n.modTime, Method: zip.Deflate}
+
+		switch {
+		case n.ref.typ == filetype.Symlink:
+			hdr.SetMode(fs.ModeSymlink | 0777)
+			hdr.Method = zip.Store
+			fw, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, n.symlinkPath)
+			return err
+		case exportIsDir(n):
+			hdr.Name = name + "/"
+			hdr.SetMode(fs.ModeDir | exportDirMode(n).Perm())
+			hdr.Method = zip.Store
+			_, err := zw.CreateHeader(hdr)
+			return err
+		case n.SpecialType():
+			// zip cant have devices, fifos or sockets
+			return nil
+		}
+
+		file, err := n.OpenFileReader()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hdr.SetMode(n.mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky))
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ----------------Helpers--------------------
+// archiveName returns the name in the archive for the path (relative to the export)
+// blank for the top if its a dir (its contents are at the top of the archive)
+func archiveName(path string, n *Fs) string {
+	if path == "/" {
+		if exportIsDir(n) {
+			return ""
+		}
+		return n.name
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// archiveMode returns the unix mode bits (i.e. 04755) for the mode
+func archiveMode(mode fs.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// ----------------Helpers--------------------
@@ -0,0 +1,31 @@
+//go:build linux
+
+package virtualfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl (linux/fs.h)
+const ficlone = 0x40049409
+
+// reflink creates dest as a copy on write clone of src (only some filesystems support it, i.e. btrfs/xfs)
+func reflink(src, dest string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.Fd(), ficlone, s.Fd()); errno != 0 {
+		d.Close()
+		os.Remove(dest)
+		return errno
+	}
+	return d.Close()
+}
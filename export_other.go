@@ -0,0 +1,10 @@
+//go:build !linux
+
+package virtualfs
+
+import "errors"
+
+// reflink isnt supported so always copy
+func reflink(src, dest string) error {
+	return errors.ErrUnsupported
+}
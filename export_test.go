@@ -0,0 +1,210 @@
+package virtualfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func assertDiskContent(t *testing.T, exp, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	fatalfIfErr(t, err, "failed to read %v", path)
+	assertEqual(t, exp, string(data), "content of %v doesnt match", path)
+}
+
+func TestExportDir(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/etc/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /etc/hello")
+		_, err = v.Hardlink("/etc/hello", "/usr/hello", 0640, time1)
+		fatalfIfErr(t, err, "failed to create /usr/hello")
+		err = createFile(v, "/usr/copy", 0600, time2, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /usr/copy")
+		_, err = v.Symlink("/etc/hello", "/usr/abs", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /usr/abs")
+		err = createFile(v, "/bar", 0700, time2, helloWorldCompressed)
+		fatalfIfErr(t, err, "failed to create /bar")
+		bar, err := v.Stat("/bar")
+		fatalfIfErr(t, err, "failed to stat /bar")
+		err = createChildFile(bar, 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create child of /bar")
+
+		dest := filepath.Join(filepath.Dir(tmp), "export")
+		err = v.ExportDir("/", dest)
+		fatalfIfErr(t, err, "failed to export")
+
+		assertDiskContent(t, "Hello, World!", filepath.Join(dest, "etc/hello"))
+		assertDiskContent(t, "Hello, World!", filepath.Join(dest, "usr/abs"))
+		assertDiskContent(t, "Hello, Foo!", filepath.Join(dest, "bar"))
+		target, err := os.Readlink(filepath.Join(dest, "usr/abs"))
+		fatalfIfErr(t, err, "failed to read link")
+		assertEqual(t, "../etc/hello", target, "absolute symlink should be relative to the export")
+
+		fi, err := os.Stat(filepath.Join(dest, "etc/hello"))
+		fatalfIfErr(t, err, "failed to stat /etc/hello")
+		assertEqual(t, os.FileMode(0640), fi.Mode(), "mode should match")
+		assert(t, fi.ModTime().Equal(time1), "mod time should match %v", fi.ModTime())
+		other, err := os.Stat(filepath.Join(dest, "usr/hello"))
+		fatalfIfErr(t, err, "failed to stat /usr/hello")
+		assert(t, os.SameFile(fi, other), "hardlink should be hardlinked")
+		copied, err := os.Stat(filepath.Join(dest, "usr/copy"))
+		fatalfIfErr(t, err, "failed to stat /usr/copy")
+		assert(t, !os.SameFile(fi, copied), "same contents shouldnt be hardlinked")
+		assertEqual(t, os.FileMode(0600), copied.Mode(), "copy should keep its mode")
+		assert(t, copied.ModTime().Equal(time2), "copy should keep its mod time %v", copied.ModTime())
+
+		err = v.ExportDir("/", dest)
+		assert(t, err != nil, "should error if dest exists")
+
+		//------------ Dest under a symlinked dir
+		linkedDir := filepath.Join(filepath.Dir(tmp), "linked-dir")
+		fatalfIfErr(t, os.Symlink(filepath.Dir(tmp), linkedDir), "failed to symlink dir")
+		err = v.ExportDir("/etc", filepath.Join(linkedDir, "through"))
+		fatalfIfErr(t, err, "failed to export under a symlinked dir")
+		assertDiskContent(t, "Hello, World!", filepath.Join(filepath.Dir(tmp), "through/hello"))
+
+		//------------ Original layer
+		original := filepath.Join(filepath.Dir(tmp), "original")
+		err = v.ExportDir("/bar", original, ExportAt(0))
+		fatalfIfErr(t, err, "failed to export /bar at 0")
+		assertDiskContent(t, helloWorldCompressed, original)
+
+		//------------ Hardlink from storage
+		linked := filepath.Join(filepath.Dir(tmp), "linked")
+		err = v.ExportDir("/etc", linked, ExportLinks(LinkHardlink))
+		fatalfIfErr(t, err, "failed to export with hardlinks")
+		fi, err = os.Stat(filepath.Join(linked, "hello"))
+		fatalfIfErr(t, err, "failed to stat linked hello")
+		stored, err := v.Stat("/etc/hello")
+		fatalfIfErr(t, err, "failed to stat /etc/hello")
+		storedFi, err := os.Stat(stored.FilePath())
+		fatalfIfErr(t, err, "failed to stat stored file")
+		assert(t, os.SameFile(fi, storedFi), "should be hardlinked to storage")
+
+		//------------ Symlink outside
+		_, err = v.Symlink("../../../etc/passwd", "/usr/escape", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /usr/escape")
+		err = v.ExportDir("/", filepath.Join(filepath.Dir(tmp), "escape"))
+		assertErr(t, ErrUnsafeSymlink, err, "should error on symlink outside of export")
+	})
+}
+
+func TestExportDirSymlinkChain(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		_, err = v.MkdirP("/a/b", 0755, time1)
+		fatalfIfErr(t, err, "failed to create /a/b")
+		_, err = v.Symlink("..", "/a/b/s", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /a/b/s")
+
+		//------------ Safe chain
+		_, err = v.Symlink("a/b/s/b/s", "/safe", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /safe")
+		err = v.ExportDir("/", filepath.Join(filepath.Dir(tmp), "safe"))
+		fatalfIfErr(t, err, "failed to export safe symlink chain")
+
+		//------------ Chain outside (each target is inside lexically)
+		_, err = v.Symlink("a/b/s/../..", "/p", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /p")
+		err = v.ExportDir("/", filepath.Join(filepath.Dir(tmp), "chain"))
+		assertErr(t, ErrUnsafeSymlink, err, "should error on symlink chain outside of export")
+
+		//------------ Symlink loop
+		err = v.Remove("/p")
+		fatalfIfErr(t, err, "failed to remove /p")
+		_, err = v.Symlink("loop", "/loop", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /loop")
+		err = v.ExportDir("/", filepath.Join(filepath.Dir(tmp), "loop"))
+		assertErr(t, ErrUnsafeSymlink, err, "should error on symlink loop")
+	})
+}
+
+func TestExportTar(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		long := "/" + strings.Repeat("long", 50)
+		err = createFile(v, long+"/hello", os.ModeSetuid|0755, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create long file")
+		err = createFile(v, "/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /hello")
+		fatalfIfErr(t, v.Chtimes("/hello", time2, time1), "failed to set /hello times")
+		fatalfIfErr(t, v.Setxattr("/hello", "user.foo", []byte("bar")), "failed to set /hello xattr")
+		_, err = v.Symlink("hello", "/link", 0777, time1)
+		fatalfIfErr(t, err, "failed to create /link")
+		_, err = v.Hardlink("/hello", "/same", 0640, time1)
+		fatalfIfErr(t, err, "failed to create /same")
+		_, err = v.MkdirP("/readonly", 0555, time1)
+		fatalfIfErr(t, err, "failed to create /readonly")
+		err = createFile(v, "/bar", 0700, time2, helloWorldCompressed)
+		fatalfIfErr(t, err, "failed to create /bar")
+		bar, err := v.Stat("/bar")
+		fatalfIfErr(t, err, "failed to stat /bar")
+		err = createChildFile(bar, 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create child of /bar")
+
+		buf := &bytes.Buffer{}
+		err = v.ExportTar("/", buf, ExportGzip())
+		fatalfIfErr(t, err, "failed to export tar")
+
+		gr, err := gzip.NewReader(buf)
+		fatalfIfErr(t, err, "failed to read gzip")
+		tr := tar.NewReader(gr)
+		headers := map[string]*tar.Header{}
+		contents := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			fatalfIfErr(t, err, "failed to read tar")
+			data, err := io.ReadAll(tr)
+			fatalfIfErr(t, err, "failed to read %v", hdr.Name)
+			headers[hdr.Name] = hdr
+			contents[hdr.Name] = string(data)
+		}
+
+		assertEqual(t, 7, len(headers), "expected 2 dirs, 3 files, a hardlink and a symlink")
+		assertEqual(t, "Hello, Foo!", contents["bar"], "should export the last layer")
+		assertEqual(t, int64(0555), headers["readonly/"].Mode, "dir mode shouldnt change")
+		assertEqual(t, byte(tar.TypeSymlink), headers["link"].Typeflag, "should be a symlink")
+		assertEqual(t, "hello", headers["link"].Linkname, "symlink target doesnt match")
+		assertEqual(t, int64(04755), headers[long[1:]+"/hello"].Mode, "mode doesnt match")
+		assert(t, headers[long[1:]+"/hello"].ModTime.Equal(time1), "mod time doesnt match")
+		// files with the same contents are only tar hardlinks if they were hardlinked
+		assertEqual(t, "Hello, World!", contents["hello"], "file should have contents")
+		assertEqual(t, "Hello, World!", contents[long[1:]+"/hello"], "same contents should have contents")
+		assertEqual(t, byte(tar.TypeLink), headers["same"].Typeflag, "should be a hardlink")
+		assertEqual(t, "hello", headers["same"].Linkname, "hardlink target doesnt match")
+		assertEqual(t, tar.FormatPAX, headers["hello"].Format, "times and xattrs need PAX")
+		assert(t, headers["hello"].AccessTime.Equal(time2), "access time doesnt match")
+		assertEqual(t, "bar", headers["hello"].PAXRecords[paxXattrPrefix+"user.foo"], "xattr doesnt match")
+
+		//------------ Original layer as zip
+		buf.Reset()
+		err = v.ExportZip("/", buf, ExportOriginal())
+		fatalfIfErr(t, err, "failed to export zip")
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		fatalfIfErr(t, err, "failed to read zip")
+		file, err := zr.Open("bar")
+		fatalfIfErr(t, err, "failed to open bar in zip")
+		data, err := io.ReadAll(file)
+		fatalfIfErr(t, err, "failed to read bar in zip")
+		assertEqual(t, helloWorldCompressed, string(data), "should export the original")
+		assertEqual(t, 7, len(zr.File), "expected 2 dirs, 3 files, a hardlink and a symlink")
+	})
+}
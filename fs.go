@@ -1,10 +1,12 @@
 package virtualfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,26 +24,64 @@ type Fs struct {
 	mode        os.FileMode
 	symlinkPath string
 	modTime     time.Time
+	times       Times
+	uid         int
+	gid         int
+	uname       string
+	gname       string
+	devMajor    int64
+	devMinor    int64
+	xattrs      map[string][]byte
+	// shared by the locations hardlinked to each other (see Hardlink), blank if not hardlinked
+	link string
 	// unique to file (checksums, filetype, etc)
 	ref *reference
 }
 
-// NewFsFromDb loads a virtual file system from a directory DB
-func NewFsFromDb(storageDir string) (*Fs, error) {
-	toReturn := &Fs{isRoot: true}
-	return toReturn, toReturn.load(storageDir)
+// NewFsFromDb loads a virtual file system from a directory DB. More can be added (files already
+// stored are deduplicated) and Close saves it again. fin.db is kept until then so if the process
+// crashes the storage dir can still be loaded (files added since are orphaned unless WithJournal is used).
+// If the last run used WithJournal and didnt Close, the changes in the journal are replayed.
+// Returns ErrStorageMismatch if opts dont store files the way they were saved (i.e. WithChunking)
+func NewFsFromDb(storageDir string, opts ...Option) (*Fs, error) {
+	db, err := newReferenceDB(storageDir, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return loadRootFs(db)
 }
 
 // NewFs creates a new virtual file system from a file or stdin
-func NewFs(storageDir, name string, mode os.FileMode, modTime time.Time, r io.Reader) (*Fs, error) {
-	err := os.Mkdir(storageDir, 0755)
+func NewFs(storageDir, name string, mode os.FileMode, modTime time.Time, r io.Reader, opts ...Option) (*Fs, error) {
+	db, err := newReferenceDB(storageDir, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Mkdir(storageDir, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create storage dir: %w", err)
 	}
 
+	return newRootFs(db, name, mode, modTime, r)
+}
+
+// NewMemFs creates a new virtual file system that keeps the files and db in memory
+// use WithMemoryLimit and WithSpillDir to control how much memory is used. Files arent on disk
+// so Open and OpenFile always return ErrNotOnDisk, use OpenReader and OpenFileReader instead
+func NewMemFs(name string, mode os.FileMode, modTime time.Time, r io.Reader, opts ...Option) (*Fs, error) {
+	db, err := newMemReferenceDB(newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return newRootFs(db, name, mode, modTime, r)
+}
+
+// newRootFs creates the root of a virtual file system using the db passed
+func newRootFs(db *referenceDB, name string, mode os.FileMode, modTime time.Time, r io.Reader) (*Fs, error) {
 	fs := &Fs{
 		isRoot:  true,
-		db:      newReferenceDB(storageDir),
+		db:      db,
 		name:    name,
 		mode:    mode,
 		modTime: modTime,
@@ -50,9 +90,23 @@ func NewFs(storageDir, name string, mode os.FileMode, modTime time.Time, r io.Re
 			children: make(map[string]*Fs),
 		},
 	}
+	db.attach(fs.ref)
 	if mode.IsDir() {
 		fs.setToDir()
-	} else {
+	}
+	// the journal needs a snapshot to start from (see save)
+	if db.journalEvery > 0 {
+		j, err := openJournal(filepath.Join(db.dbDir, finJournal), db.journalEvery)
+		if err != nil {
+			return nil, err
+		}
+		db.journal = j
+		if err := fs.save(); err != nil {
+			j.close()
+			return nil, err
+		}
+	}
+	if !mode.IsDir() {
 		if err := fs.copyReader(r); err != nil {
 			return nil, fmt.Errorf("couldn't copy from reader (%v) - %w", name, err)
 		}
@@ -61,6 +115,44 @@ func NewFs(storageDir, name string, mode os.FileMode, modTime time.Time, r io.Re
 	return fs, nil
 }
 
+// loadRootFs loads the root of a virtual file system from the db passed replaying the journal (if any)
+func loadRootFs(db *referenceDB) (*Fs, error) {
+	fs := &Fs{isRoot: true, db: db}
+	// locked first so the journal (and fin.db) of another process writing it isnt changed
+	var j *journal
+	if db.journalEvery > 0 {
+		var err error
+		j, err = openJournal(filepath.Join(db.dbDir, finJournal), db.journalEvery)
+		if err != nil {
+			return fs, err
+		}
+	}
+	if err := fs.load(); err != nil {
+		j.close()
+		return fs, err
+	}
+	db.attach(fs.ref)
+	replayed, err := fs.replayJournal()
+	if err != nil {
+		j.close()
+		return fs, err
+	}
+	// so removing files frees the chunks only they use
+	if err := trackChunks(db.store, db.storedNames()); err != nil {
+		j.close()
+		return fs, err
+	}
+	if j == nil {
+		return fs, nil
+	}
+	db.journal = j
+	// compact what was replayed so the new journal starts from it
+	if replayed > 0 {
+		return fs, fs.save()
+	}
+	return fs, j.reset(db.checksum)
+}
+
 // ----------------Helpers--------------------
 // newFsWithReference creates a new file info object
 func (f *Fs) newFsWithReference(name string, mode os.FileMode, modTime time.Time, reference *reference) *Fs {
@@ -95,6 +187,22 @@ func (n *Fs) setToSym(oldname string) *Fs {
 	return n
 }
 
+// setToSpecial sets the fs to a device, fifo or socket (based on the mode) and the device numbers
+func (n *Fs) setToSpecial(mode os.FileMode, major, minor int64) (*Fs, error) {
+	typ, ok := specialFiletype(mode)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, mode.Type())
+	}
+	n.mode = n.mode&^fs.ModeType | mode&fs.ModeType
+	if typ == CharDeviceType {
+		// go char devices are both
+		n.mode |= fs.ModeDevice
+	}
+	n.ref.typ = typ
+	n.devMajor, n.devMinor = major, minor
+	return n, nil
+}
+
 // updateIfDuplicateRef if sha512 already seen it will use that ref and return true
 // if its the first time we see the sha512 then dont change anything and return false
 func (n *Fs) updateIfDuplicateRef() (updated bool, err error) {
@@ -105,8 +213,10 @@ func (n *Fs) updateIfDuplicateRef() (updated bool, err error) {
 		err = n.checkIfCircular(n.ref.sha512, true)
 		if err != nil {
 			n.ref = oldRef // revert to old reference
-			updated = false
+			return false, err
 		}
+		n.db.attach(n.ref)
+		err = n.db.detach(oldRef)
 	}
 	return
 }
@@ -155,7 +265,49 @@ func (v *Fs) Close() error {
 	}
 
 	v.closed = true
-	return v.save()
+	if err := v.save(); err != nil {
+		return err
+	}
+	// everything is in the snapshot now, removed before its unlocked so only this process removes it
+	if v.db.journal != nil {
+		err := os.Remove(filepath.Join(v.db.dbDir, finJournal))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := v.db.journal.close(); err != nil {
+			return err
+		}
+		v.db.journal = nil
+	}
+	if v.db.catalog != nil {
+		return v.db.catalog.save()
+	}
+	return nil
+}
+
+// Checkpoint saves the virtual file system to the disk without closing it so other processes can read
+// (see NewFsFromDb) a consistent snapshot of progress and a crashed process can start from the last
+// checkpoint, compacting the journal (see JournalDue). Returns ErrJournalLocked if another process is
+// journaling the storage dir. NOTE: dont change the tree (i.e. ImportDir) while checkpointing
+func (v *Fs) Checkpoint() error {
+	if err := v.isClosed(); err != nil {
+		return err
+	}
+	if !v.IsRoot() {
+		return ErrChild
+	}
+	return v.checkpoint()
+}
+
+// checkpoint saves the tree (see save) and the catalog (if any)
+func (v *Fs) checkpoint() error {
+	if err := v.save(); err != nil {
+		return err
+	}
+	if v.db.catalog != nil {
+		return v.db.catalog.save()
+	}
+	return nil
 }
 
 // isClosed checks if the virtual file system is closed
@@ -0,0 +1,108 @@
+package virtualfs
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Times are the times of a file, zero if unknown
+type Times struct {
+	Access time.Time
+	Modify time.Time
+	Change time.Time
+	Birth  time.Time
+}
+
+// Chmod sets the permissions (and setuid, setgid and sticky bits) of the entry at path
+// NOTE: like the other attributes this is for the entry (the first layer, see StatAt)
+func (v *Fs) Chmod(path string, mode os.FileMode) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+
+	const settable = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	n.mode = n.mode&^settable | mode&settable
+	return v.journalAttr(path)
+}
+
+// Chtimes sets the access and modification times of the entry at path, zero times arent changed
+func (v *Fs) Chtimes(path string, atime, mtime time.Time) error {
+	return v.SetTimes(path, Times{Access: atime, Modify: mtime})
+}
+
+// SetTimes sets the times of the entry at path, zero times arent changed
+func (v *Fs) SetTimes(path string, times Times) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+
+	if !times.Access.IsZero() {
+		n.times.Access = times.Access
+	}
+	if !times.Modify.IsZero() {
+		n.modTime = times.Modify
+	}
+	if !times.Change.IsZero() {
+		n.times.Change = times.Change
+	}
+	if !times.Birth.IsZero() {
+		n.times.Birth = times.Birth
+	}
+	return v.journalAttr(path)
+}
+
+// Chown sets the owner user and group ids of the entry at path, -1 isnt changed (like os.Chown)
+func (v *Fs) Chown(path string, uid, gid int) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+
+	if uid != -1 {
+		n.uid = uid
+	}
+	if gid != -1 {
+		n.gid = gid
+	}
+	return v.journalAttr(path)
+}
+
+// ChownNames sets the owner user and group names of the entry at path, blank isnt changed
+func (v *Fs) ChownNames(path, user, group string) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+
+	if user != "" {
+		n.uname = user
+	}
+	if group != "" {
+		n.gname = group
+	}
+	return v.journalAttr(path)
+}
+
+// ----------------Helpers--------------------
+// location returns the entry at path (the first layer), v if path is the root
+func (v *Fs) location(path string) (*Fs, error) {
+	err := v.isClosed()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := split(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return v, nil
+	}
+	_, _, n, err := v.entry(path)
+	return n, err
+}
+
+// ----------------Helpers--------------------
@@ -0,0 +1,111 @@
+package virtualfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/etc/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /etc/hello")
+
+		fatalfIfErr(t, v.Chmod("/etc/hello", 0755|os.ModeSetuid), "failed to chmod")
+		fatalfIfErr(t, v.Chmod("/etc", 0700), "failed to chmod dir")
+		fatalfIfErr(t, v.Chtimes("/etc/hello", time3, time2), "failed to chtimes")
+		fatalfIfErr(t, v.SetTimes("/etc/hello", Times{Birth: time1}), "failed to set times")
+		fatalfIfErr(t, v.Chown("/etc/hello", 1000, 100), "failed to chown")
+		fatalfIfErr(t, v.Chown("/etc/hello", -1, 1001), "failed to chown group")
+		fatalfIfErr(t, v.ChownNames("/etc/hello", "foo", "bar"), "failed to chown names")
+		fatalfIfErr(t, v.Chown("/", 0, 0), "failed to chown root")
+		err = v.Chmod("/missing", 0755)
+		assertErr(t, ErrNotFound, err, "should error if path doesnt exist")
+
+		assertAttributes := func(v *Fs, str string) {
+			t.Helper()
+			hello, err := v.Stat("/etc/hello")
+			fatalfIfErr(t, err, "%v failed to stat /etc/hello", str)
+			assertEqual(t, 0755|os.ModeSetuid, hello.Mode(), "%v mode doesnt match", str)
+			assertEqual(t, Times{Access: time3, Modify: time2, Birth: time1}, hello.Times(), "%v times dont match", str)
+			assertEqual(t, 1000, hello.Uid(), "%v uid doesnt match", str)
+			assertEqual(t, 1001, hello.Gid(), "%v gid doesnt match", str)
+			assertEqual(t, "foo", hello.User(), "%v user doesnt match", str)
+			assertEqual(t, "bar", hello.Group(), "%v group doesnt match", str)
+
+			etc, err := v.Stat("/etc")
+			fatalfIfErr(t, err, "%v failed to stat /etc", str)
+			assertEqual(t, 0700|fs.ModeDir, etc.Mode(), "%v chmod should keep the dir bit", str)
+		}
+		assertAttributes(v, "before closing")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		err = v.Chmod("/etc", 0755)
+		assertErr(t, ErrClosed, err, "should error after closing")
+
+		newV, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertAttributes(newV, "after loading")
+	})
+}
+
+func TestMknod(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		_, err = v.Mknod("/dev/null", fs.ModeCharDevice|0666, 1, 3, time1)
+		fatalfIfErr(t, err, "failed to create /dev/null")
+		_, err = v.Mknod("/dev/sda", fs.ModeDevice|0660, 8, 0, time1)
+		fatalfIfErr(t, err, "failed to create /dev/sda")
+		_, err = v.Mknod("/run/fifo", fs.ModeNamedPipe|0600, 0, 0, time1)
+		fatalfIfErr(t, err, "failed to create /run/fifo")
+		_, err = v.Mknod("/run/file", 0600, 0, 0, time1)
+		assertErr(t, ErrUnsupportedType, err, "should error if not a special mode")
+
+		null, err := v.Stat("/dev/null")
+		fatalfIfErr(t, err, "failed to stat /dev/null")
+		assertEqual(t, fs.ModeDevice|fs.ModeCharDevice|0666, null.Mode(), "mode should be a char device")
+		assertEqual(t, CharDeviceType, null.Filetype(), "should be a char device")
+		assert(t, null.SpecialType(), "devices are special")
+		sys, ok := null.Sys().(*SysInfo)
+		assert(t, ok, "sys should be SysInfo")
+		assertEqual(t, int64(1), sys.DevMajor, "major doesnt match")
+		assertEqual(t, int64(3), sys.DevMinor, "minor doesnt match")
+		_, err = null.OpenFile()
+		assert(t, err != nil, "shouldnt be able to open a device")
+
+		buf := &bytes.Buffer{}
+		fatalfIfErr(t, v.ExportTar("/", buf), "failed to export tar")
+		tr := tar.NewReader(buf)
+		types := map[string]byte{}
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			fatalfIfErr(t, err, "failed to read tar")
+			types[hdr.Name] = hdr.Typeflag
+		}
+		assertEqual(t, byte(tar.TypeChar), types["dev/null"], "should be a char device in tar")
+		assertEqual(t, byte(tar.TypeBlock), types["dev/sda"], "should be a block device in tar")
+		assertEqual(t, byte(tar.TypeFifo), types["run/fifo"], "should be a fifo in tar")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		sda, err := newV.Stat("/dev/sda")
+		fatalfIfErr(t, err, "failed to stat /dev/sda")
+		assertEqual(t, BlockDeviceType, sda.Filetype(), "should be a block device after loading")
+		major, minor := sda.Device()
+		assertEqual(t, int64(8), major, "major doesnt match after loading")
+		assertEqual(t, int64(0), minor, "minor doesnt match after loading")
+	})
+}
@@ -22,7 +22,61 @@ func (fi *Fs) IsDir() bool {
 	return fi.mode.IsDir()
 }
 func (fi *Fs) Sys() any {
-	return nil
+	return &SysInfo{
+		Uid:      fi.uid,
+		Gid:      fi.gid,
+		User:     fi.uname,
+		Group:    fi.gname,
+		Times:    fi.Times(),
+		DevMajor: fi.devMajor,
+		DevMinor: fi.devMinor,
+	}
 }
 
 // ---------------------FileInfo Methods--------------------
+
+// ---------------------Owner and Times--------------------
+// SysInfo is returned by Sys with the owner, times and device numbers (for devices, see Mknod)
+type SysInfo struct {
+	Uid      int
+	Gid      int
+	User     string
+	Group    string
+	Times    Times
+	DevMajor int64
+	DevMinor int64
+}
+
+// Device returns the major and minor device numbers (0 if not a device)
+func (fi *Fs) Device() (int64, int64) {
+	return fi.devMajor, fi.devMinor
+}
+
+// Times returns the access, change and birth times (zero if unknown), Modify is the same as ModTime
+func (fi *Fs) Times() Times {
+	times := fi.times
+	times.Modify = fi.modTime
+	return times
+}
+
+// Uid returns the owner user id
+func (fi *Fs) Uid() int {
+	return fi.uid
+}
+
+// Gid returns the owner group id
+func (fi *Fs) Gid() int {
+	return fi.gid
+}
+
+// User returns the owner user name (blank if unknown)
+func (fi *Fs) User() string {
+	return fi.uname
+}
+
+// Group returns the owner group name (blank if unknown)
+func (fi *Fs) Group() string {
+	return fi.gname
+}
+
+// ---------------------Owner and Times--------------------
@@ -2,6 +2,8 @@ package virtualfs
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
 
 	"github.com/jonathongardner/fifo/filetype"
 )
@@ -53,7 +55,32 @@ func (fi *Fs) ErrorId() error {
 	return fmt.Errorf("id: %v, name: %v, type: %v,", fi.ref.id, fi.name, fi.ref.typ)
 }
 
-// SpecialType returns true if dir or symlink
+// filetypes for special files (see Mknod)
+var CharDeviceType = filetype.Filetype{Mimetype: "chardevice/chardevice"}
+var BlockDeviceType = filetype.Filetype{Mimetype: "blockdevice/blockdevice"}
+var FifoType = filetype.Filetype{Mimetype: "fifo/fifo"}
+var SocketType = filetype.Filetype{Mimetype: "socket/socket"}
+
+// SpecialType returns true if dir, symlink, device, fifo or socket (i.e. has no contents)
 func (fi *Fs) SpecialType() bool {
-	return fi.ref.typ == filetype.Dir || fi.ref.typ == filetype.Symlink
+	switch fi.ref.typ {
+	case filetype.Dir, filetype.Symlink, CharDeviceType, BlockDeviceType, FifoType, SocketType:
+		return true
+	}
+	return false
+}
+
+// specialFiletype returns the filetype for a device, fifo or socket mode
+func specialFiletype(mode os.FileMode) (filetype.Filetype, bool) {
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		return CharDeviceType, true
+	case mode&fs.ModeDevice != 0:
+		return BlockDeviceType, true
+	case mode&fs.ModeNamedPipe != 0:
+		return FifoType, true
+	case mode&fs.ModeSocket != 0:
+		return SocketType, true
+	}
+	return filetype.Filetype{}, false
 }
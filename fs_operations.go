@@ -3,12 +3,14 @@ package virtualfs
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jonathongardner/fifo/filetype"
 	// log "github.com/sirupsen/logrus"
 )
@@ -45,7 +47,6 @@ func (n *Fs) mkdirPRecursive(paths []string, perm os.FileMode, modTime time.Time
 		return nil, err
 	}
 	if child == nil || child.ref.typ != filetype.Dir {
-		// NOTE:orphan if the child is not a directory then we could orphan a file if its not used anywhere else
 		child, err = n.ref.setChildren(n.newFs(firstPath, perm, modTime).setToDir())
 		// shouldnt happen since `getChild` would have returned error first but in case logic changes in setChild
 		if err != nil {
@@ -87,10 +88,37 @@ func (n *Fs) symlinkRecursive(linkname string, paths []string, perm os.FileMode,
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: orphan this could orphin some references, might want to clean up if reference is not needed
 	return dir.ref.setChildren(n.newFs(name, perm, modTime).setToSym(linkname))
 }
 
+// Mknod creates a char or block device, fifo or socket (based on the mode type) at the path
+// major and minor are the device numbers (0 if not a device)
+func (v *Fs) Mknod(path string, mode os.FileMode, major, minor int64, modTime time.Time) (*Fs, error) {
+	err := v.isClosed()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := split(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, ErrOutsideFilesystem
+	}
+
+	last := len(paths) - 1
+	dir, err := v.mkdirPRecursive(paths[:last], mode.Perm(), modTime)
+	if err != nil {
+		return nil, err
+	}
+	node, err := v.newFs(paths[last], mode, modTime).setToSpecial(mode, major, minor)
+	if err != nil {
+		return nil, err
+	}
+	return dir.ref.setChildren(node)
+}
+
 // Hardlink creates a hardlink at the path
 // source is the path to the file to link to
 func (v *Fs) Hardlink(source, newname string, perm os.FileMode, modTime time.Time) (*Fs, error) {
@@ -111,15 +139,28 @@ func (v *Fs) Hardlink(source, newname string, perm os.FileMode, modTime time.Tim
 	if err != nil {
 		return nil, err
 	}
+	// files with the same contents share a reference so the link is what makes them hardlinks (see ExportDir)
+	newLink := ln.link == ""
+	if newLink {
+		ln.link = uuid.New().String()
+	}
 	newRoot, err := v.hardlinkRecursive(ln, paths, perm, modTime)
-	return newRoot, err
+	if err != nil {
+		if newLink {
+			ln.link = ""
+		}
+		return nil, err
+	}
+	if newLink {
+		return newRoot, v.journalLink(source, ln.link)
+	}
+	return newRoot, nil
 }
 
 // hardlink creates a hardlink at the path
 // ln is the file to link to
 func (n *Fs) hardlinkRecursive(ln *Fs, paths []string, perm os.FileMode, modTime time.Time) (*Fs, error) {
 	if len(paths) == 0 {
-		// NOTE: orphan this could orphin some references, might want to clean up if reference is not needed
 		return n.ref.setChild(n.newFs(n.name, perm, modTime))
 	}
 	last := len(paths) - 1
@@ -129,8 +170,9 @@ func (n *Fs) hardlinkRecursive(ln *Fs, paths []string, perm os.FileMode, modTime
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: orphan this could orphin some references, might want to clean up if reference is not needed
-	return dir.ref.setChildren(n.newFsWithReference(name, perm, modTime, ln.ref))
+	hardlink := n.newFsWithReference(name, perm, modTime, ln.ref)
+	hardlink.link = ln.link
+	return dir.ref.setChildren(hardlink)
 }
 
 // CreateWithoutPath creates a child file
@@ -165,7 +207,6 @@ func (v *Fs) Create(path string, perm os.FileMode, modTime time.Time) (*Fs, erro
 
 func (n *Fs) createRecursive(paths []string, perm os.FileMode, modTime time.Time) (*Fs, error) {
 	if len(paths) == 0 {
-		// NOTE: orphan this could orphin some references, might want to clean up if reference is not needed
 		return n.ref.setChild(n.newFs(n.name, perm, modTime))
 	}
 	last := len(paths) - 1
@@ -175,7 +216,6 @@ func (n *Fs) createRecursive(paths []string, perm os.FileMode, modTime time.Time
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: orphan this could orphin some references, might want to clean up if reference is not needed
 	return dir.ref.setChildren(n.newFs(name, perm, modTime))
 }
 
@@ -212,39 +252,114 @@ func (v *Fs) StatAt(path string, at int) (*Fs, error) {
 // when the file is closed if it matches another file
 // (based on sha256) then it will be linked to that file
 func (n *Fs) CreateFile() (*myFile, error) {
-	return createMyWriterCloser(n, n.FilePath())
+	return createMyWriterCloser(n)
+}
+
+// CreateSection sets the contents to length bytes of source starting at offset without
+// copying them (i.e. uncompressed tar members). The section is hashed and typed like any
+// other file and OpenFile returns a reader over the section of source
+func (n *Fs) CreateSection(source *Fs, offset, length int64) error {
+	if source.SpecialType() {
+		return fmt.Errorf("cannot create a section of a %v", source.ref.typ.Mimetype)
+	}
+	if offset < 0 || length < 0 || offset+length > source.ref.size {
+		return fmt.Errorf("%w: section %v+%v of %v", ErrOutOfRange, offset, length, source.ref.size)
+	}
+	// a section of itself (or of a section of it) would never finish opening
+	for r := source.ref; r != nil; r = r.source {
+		if r == n.ref {
+			return fmt.Errorf("%w: section of itself", ErrCircularReference)
+		}
+	}
+
+	file, err := source.OpenFileReader()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if n.ref.source != nil {
+		if err := n.db.detach(n.ref.source); err != nil {
+			return err
+		}
+	}
+	n.ref.source = source.ref
+	n.ref.offset = offset
+	n.db.attach(source.ref)
+
+	section := newMyFile(n, discard{})
+	if _, err := io.Copy(section, io.NewSectionReader(file, offset, length)); err != nil {
+		section.Close()
+		return err
+	}
+	return section.Close()
 }
 
 // OpenFile opens the Fs base file for reading
-// returns an error if the file is a directory or a symlink
+// returns an error if the file is a directory or a symlink, ErrNotOnDisk if it isnt stored as
+// a file on disk (i.e. NewMemFs, WithCompression or a section) use OpenFileReader for those
 func (n *Fs) OpenFile() (*os.File, error) {
+	file, err := n.OpenFileReader()
+	if err != nil {
+		return nil, err
+	}
+	osFile, ok := file.(*os.File)
+	if !ok {
+		file.Close()
+		return nil, ErrNotOnDisk
+	}
+	return osFile, nil
+}
+
+// OpenFileReader opens the Fs base file for reading however its stored
+// returns an error if the file is a directory or a symlink
+func (n *Fs) OpenFileReader() (File, error) {
 	if n.ref.typ == filetype.Symlink {
 		return nil, fmt.Errorf("cannot open a symlink")
 	}
 	if n.ref.typ == filetype.Dir {
 		return nil, fmt.Errorf("cannot open a directory")
 	}
+	if n.SpecialType() {
+		return nil, fmt.Errorf("cannot open a %v", n.ref.typ.Mimetype)
+	}
 
-	return n.ref.open(n.db.storageDir)
+	return n.ref.open(n.db.store)
 }
 
 // FilePath returns the path to the file in the storage directory
+// blank if the fs is in memory or a section (see CreateSection). NOTE: the file is stored as is only if
+// no storage options (i.e. WithCompression) are used, otherwise use OpenFileReader
 func (n *Fs) FilePath() string {
 	return n.ref.storagePath(n.db.storageDir)
 }
 
-// Open returns an os.File for the path, if no path is given, it will return the root
+// Open returns an os.File for the path, if no path is given, it will return the root (see OpenFile)
 func (v *Fs) Open(path string) (*os.File, error) {
-	err := v.isClosed()
+	toWalk, err := v.openFs(path)
 	if err != nil {
 		return nil, err
 	}
+	return toWalk.OpenFile()
+}
 
-	toWalk, _, err := v.fsFrom(path, -1)
+// OpenReader returns a File for the path however its stored (see OpenFileReader)
+func (v *Fs) OpenReader(path string) (File, error) {
+	toWalk, err := v.openFs(path)
 	if err != nil {
 		return nil, err
 	}
-	return toWalk.OpenFile()
+	return toWalk.OpenFileReader()
+}
+
+func (v *Fs) openFs(path string) (*Fs, error) {
+	err := v.isClosed()
+	if err != nil {
+		return nil, err
+	}
+
+	toWalk, _, err := v.fsFrom(path, -1)
+	return toWalk, err
 }
 
 // -------------------------File------------------------
@@ -0,0 +1,114 @@
+package virtualfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Remove removes the file, symlink or empty dir at path, including any child layers (i.e.
+// the decompressed contents of foo.gz). Stored files no longer used anywhere are deleted.
+// Returns ErrNotEmpty if it (or any of its layers) has children, use RemoveAll for that
+func (v *Fs) Remove(path string) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	parent, name, node, err := v.entry(path)
+	if err != nil {
+		return err
+	}
+	for n := node; n != nil; n = n.ref.child {
+		if len(n.ref.children) > 0 {
+			return fmt.Errorf("%w: %v", ErrNotEmpty, path)
+		}
+	}
+
+	delete(parent.ref.children, name)
+	err = v.db.record(journalRecord{Op: journalUnset, Parent: parent.ref.id, Name: name})
+	return errors.Join(err, v.db.detach(node.ref))
+}
+
+// RemoveAll removes path and everything under it (see Remove), returns nil if path doesnt exist
+func (v *Fs) RemoveAll(path string) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	parent, name, node, err := v.entry(path)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	delete(parent.ref.children, name)
+	err = v.db.record(journalRecord{Op: journalUnset, Parent: parent.ref.id, Name: name})
+	return errors.Join(err, v.db.detach(node.ref))
+}
+
+// RemoveLayer removes the layer at (see StatAt) and every layer after it from path, i.e.
+// RemoveLayer("/foo.tar.gz", 1) removes the decompressed foo.tar and its contents. at must be at least 1
+func (v *Fs) RemoveLayer(path string, at int) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+	if at < 1 {
+		return fmt.Errorf("%w: layer %v", ErrOutOfRange, at)
+	}
+
+	paths, err := split(path)
+	if err != nil {
+		return err
+	}
+	node := v
+	if len(paths) > 0 {
+		_, _, node, err = v.entry(path)
+		if err != nil {
+			return err
+		}
+	}
+	for range at - 1 {
+		node = node.ref.child
+		if node == nil {
+			return fmt.Errorf("%w: layer %v of %v", ErrNotFound, at, path)
+		}
+	}
+	if node.ref.child == nil {
+		return fmt.Errorf("%w: layer %v of %v", ErrNotFound, at, path)
+	}
+
+	child := node.ref.child
+	node.ref.child = nil
+	err = v.db.record(journalRecord{Op: journalUnsetChild, Id: node.ref.id})
+	return errors.Join(err, v.db.detach(child.ref))
+}
+
+// ----------------Helpers--------------------
+// entry returns the parent (last layer), name and first layer of the entry at path
+func (v *Fs) entry(path string) (*Fs, string, *Fs, error) {
+	paths, err := split(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(paths) == 0 {
+		return nil, "", nil, fmt.Errorf("%w: cant remove root", ErrOutsideFilesystem)
+	}
+
+	dir, name := filepath.Join(paths[:len(paths)-1]...), paths[len(paths)-1]
+	parent, _, err := v.fsFrom(dir, -1)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", err, path)
+	}
+	node, ok := parent.ref.children[name]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrNotFound, path)
+	}
+	return parent, name, node, nil
+}
+
+// ----------------Helpers--------------------
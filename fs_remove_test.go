@@ -0,0 +1,136 @@
+package virtualfs
+
+import (
+	"testing"
+)
+
+func TestRemove(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/etc/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /etc/hello")
+		err = createFile(v, "/usr/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /usr/hello")
+		err = createFile(v, "/usr/foo", 0640, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /usr/foo")
+		assertTmpDirFileCount(t, 2, tmp, "two files stored")
+
+		err = v.Remove("/usr")
+		assertErr(t, ErrNotEmpty, err, "should error removing dir with children")
+		err = v.Remove("/")
+		assertErr(t, ErrOutsideFilesystem, err, "should error removing root")
+		err = v.Remove("/usr/bar")
+		assertErr(t, ErrNotFound, err, "should error removing file that doesnt exist")
+
+		err = v.Remove("/usr/hello")
+		fatalfIfErr(t, err, "failed to remove /usr/hello")
+		assertTmpDirFileCount(t, 2, tmp, "still used by /etc/hello")
+		assertContent(t, "Hello, World!", v, "/etc/hello")
+
+		err = v.RemoveAll("/etc")
+		fatalfIfErr(t, err, "failed to remove /etc")
+		assertTmpDirFileCount(t, 1, tmp, "hello should be removed")
+		_, err = v.Stat("/etc/hello")
+		assertErr(t, ErrNotFound, err, "should be removed")
+		fatalfIfErr(t, v.RemoveAll("/etc"), "remove all should be fine if doesnt exist")
+
+		//------------ Layers
+		err = createFile(v, "/bar", 0700, time2, helloWorldCompressed)
+		fatalfIfErr(t, err, "failed to create /bar")
+		bar, err := v.Stat("/bar")
+		fatalfIfErr(t, err, "failed to stat /bar")
+		err = createChildFile(bar, 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create child of /bar")
+		assertTmpDirFileCount(t, 3, tmp, "compressed and decompressed stored")
+
+		err = v.RemoveLayer("/bar", 2)
+		assertErr(t, ErrNotFound, err, "should error removing layer that doesnt exist")
+		err = v.RemoveLayer("/bar", 1)
+		fatalfIfErr(t, err, "failed to remove layer")
+		assertTmpDirFileCount(t, 2, tmp, "decompressed should be removed")
+		assertContent(t, helloWorldCompressed, v, "/bar")
+
+		//------------ Reload
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertContent(t, "Hello, Foo!", newV, "/usr/foo")
+		_, err = newV.Stat("/usr/hello")
+		assertErr(t, ErrNotFound, err, "should still be removed after reload")
+	})
+}
+
+func TestRemoveLinks(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/dir/hello", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /dir/hello")
+		_, err = v.Hardlink("/dir", "/linked", 0755, time1)
+		fatalfIfErr(t, err, "failed to hardlink /dir")
+		err = createFile(v, "/foo", 0640, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /foo")
+		assertTmpDirFileCount(t, 2, tmp, "two files stored")
+
+		//------------ Replaced entries are removed
+		err = createFile(v, "/foo", 0640, time1, "Hello, Bar!")
+		fatalfIfErr(t, err, "failed to replace /foo")
+		assertTmpDirFileCount(t, 2, tmp, "replaced file should be removed")
+
+		//------------ Hardlinked dir keeps its children
+		fatalfIfErr(t, v.RemoveAll("/dir"), "failed to remove /dir")
+		assertTmpDirFileCount(t, 2, tmp, "still used by /linked")
+		assertContent(t, "Hello, World!", v, "/linked/hello")
+
+		//------------ Sections keep their source
+		err = createFile(v, "/archive", 0640, time1, "Hello, Foo!Hello, Baz!")
+		fatalfIfErr(t, err, "failed to create /archive")
+		archive, err := v.Stat("/archive")
+		fatalfIfErr(t, err, "failed to stat /archive")
+		member, err := v.Create("/member", 0640, time1)
+		fatalfIfErr(t, err, "failed to create /member")
+		fatalfIfErr(t, member.CreateSection(archive, 11, 11), "failed to create section")
+		fatalfIfErr(t, v.Remove("/archive"), "failed to remove /archive")
+		assertContent(t, "Hello, Baz!", v, "/member")
+		fatalfIfErr(t, v.Remove("/member"), "failed to remove /member")
+		assertTmpDirFileCount(t, 2, tmp, "archive should be removed with its section")
+
+		//------------ Counted on load
+		fatalfIfErr(t, v.Close(), "failed to close")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		fatalfIfErr(t, v.Remove("/foo"), "failed to remove /foo")
+		assertTmpDirFileCount(t, 2, tmp, "foo should be removed (hello and fin.db left)")
+		fatalfIfErr(t, v.RemoveAll("/linked"), "failed to remove /linked")
+		assertTmpDirFileCount(t, 1, tmp, "hello should be removed")
+	})
+}
+
+func TestRemoveSameContents(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/first", 0640, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /first")
+		err = createFile(v, "/second", 0640, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /second")
+		first, err := v.Stat("/first")
+		fatalfIfErr(t, err, "failed to stat /first")
+		second, err := v.Stat("/second")
+		fatalfIfErr(t, err, "failed to stat /second")
+		// references saved separately load separately even with the same contents
+		second.ref.sha512 = first.ref.sha512
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertTmpDirFileCount(t, 3, tmp, "both files and fin.db stored")
+		fatalfIfErr(t, v.Remove("/second"), "failed to remove /second")
+		assertTmpDirFileCount(t, 2, tmp, "second should be removed")
+		fatalfIfErr(t, v.Remove("/first"), "failed to remove /first")
+		assertTmpDirFileCount(t, 1, tmp, "first should be removed")
+	})
+}
@@ -0,0 +1,125 @@
+package virtualfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Rename moves the entry at oldpath (with its layers and children) to newpath, creating
+// parent dirs (0755) as needed. Returns ErrFileExists if newpath exists (see RenameReplace), ErrNotDir
+// if its parent isnt a dir and ErrMoveIntoItself if its under oldpath (including through a hardlink).
+// NOTE: only the name of the entry changes, layers keep their name since they might be shared
+func (v *Fs) Rename(oldpath, newpath string) error {
+	return v.rename(oldpath, newpath, false)
+}
+
+// RenameReplace is Rename but replaces anything at newpath (see Remove)
+func (v *Fs) RenameReplace(oldpath, newpath string) error {
+	return v.rename(oldpath, newpath, true)
+}
+
+func (v *Fs) rename(oldpath, newpath string, replace bool) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	oldParent, oldName, node, err := v.entry(oldpath)
+	if err != nil {
+		return err
+	}
+	newPaths, err := split(newpath)
+	if err != nil {
+		return err
+	}
+	if len(newPaths) == 0 {
+		return fmt.Errorf("%w: cant replace root", ErrOutsideFilesystem)
+	}
+	dir, newName := newPaths[:len(newPaths)-1], newPaths[len(newPaths)-1]
+	if v.onPath(node.ref, dir) {
+		return fmt.Errorf("%w: %v -> %v", ErrMoveIntoItself, oldpath, newpath)
+	}
+
+	newParent, missing, err := v.existingDir(dir)
+	if err != nil {
+		return err
+	}
+	var existing *Fs
+	ok := false
+	if len(missing) == 0 {
+		existing, ok = newParent.ref.children[newName]
+	}
+	if existing == node {
+		return nil
+	}
+	if ok && !replace {
+		return fmt.Errorf("%w: %v", ErrFileExists, newpath)
+	}
+	if newParent.ref.child != nil {
+		return ErrAlreadyHasChild
+	}
+	// created once the rename cant fail so a failed one doesnt leave dirs behind
+	if len(missing) > 0 {
+		if newParent, err = newParent.mkdirPRecursive(missing, 0755, node.modTime); err != nil {
+			return err
+		}
+	}
+
+	// not setChildren since the node is moved not created (see journalMove)
+	node.name = newName
+	newParent.ref.children[newName] = node
+	delete(oldParent.ref.children, oldName)
+	err = v.db.record(journalRecord{Op: journalMove, Parent: oldParent.ref.id, Name: oldName, To: newParent.ref.id, NewName: newName})
+	if ok {
+		return errors.Join(err, v.db.detach(existing.ref))
+	}
+	return err
+}
+
+// ----------------Helpers--------------------
+// existingDir returns the last layer of the part of dir that exists and the names after it that dont,
+// ErrNotDir if any of it isnt a dir (layers with children, i.e. an extracted tar, are dirs too)
+func (v *Fs) existingDir(dir []string) (*Fs, []string, error) {
+	n := v
+	for i := 0; ; i++ {
+		for n.ref.child != nil {
+			n = n.ref.child
+		}
+		if !n.IsDir() && len(n.ref.children) == 0 {
+			return nil, nil, fmt.Errorf("%w: /%v", ErrNotDir, strings.Join(dir[:i], "/"))
+		}
+		if i == len(dir) {
+			return n, nil, nil
+		}
+		child, ok := n.ref.children[dir[i]]
+		if !ok {
+			return n, dir[i:], nil
+		}
+		n = child
+	}
+}
+
+// onPath returns true if ref is an entry (or layer) on paths from n (the part that exists) so
+// moving an entry with ref there would make it its own parent, even if its reached through a hardlink
+func (n *Fs) onPath(ref *reference, paths []string) bool {
+	for {
+		last := n
+		for layer := n; layer != nil; layer = layer.ref.child {
+			if layer.ref == ref {
+				return true
+			}
+			last = layer
+		}
+		if len(paths) == 0 {
+			return false
+		}
+		child, ok := last.ref.children[paths[0]]
+		if !ok {
+			return false
+		}
+		n, paths = child, paths[1:]
+	}
+}
+
+// ----------------Helpers--------------------
@@ -0,0 +1,65 @@
+package virtualfs
+
+import (
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/data", 0700, time2, helloWorldCompressed)
+		fatalfIfErr(t, err, "failed to create /data")
+		data, err := v.Stat("/data")
+		fatalfIfErr(t, err, "failed to stat /data")
+		err = createChildFile(data, 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create child of /data")
+		err = createFile(v, "/etc/foo", 0640, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /etc/foo")
+
+		err = v.Rename("/data", "/new/hello.gz")
+		fatalfIfErr(t, err, "failed to rename /data")
+		_, err = v.Stat("/data")
+		assertErr(t, ErrNotFound, err, "old path should be gone")
+		hello, err := v.StatAt("/new/hello.gz", 0)
+		fatalfIfErr(t, err, "failed to stat /new/hello.gz")
+		assertEqual(t, "hello.gz", hello.Name(), "name should change")
+		assertContent(t, "Hello, World!", v, "/new/hello.gz")
+
+		err = v.Rename("/new", "/new/more")
+		assertErr(t, ErrMoveIntoItself, err, "should error moving into itself")
+		err = v.Rename("/etc/foo", "/new/hello.gz")
+		assertErr(t, ErrFileExists, err, "should error on conflict")
+		err = v.Rename("/missing", "/other")
+		assertErr(t, ErrNotFound, err, "should error if old path doesnt exist")
+		fatalfIfErr(t, v.Rename("/etc/foo", "/etc/foo"), "rename to itself should do nothing")
+
+		assertTmpDirFileCount(t, 3, tmp, "three files stored")
+		err = v.RenameReplace("/etc/foo", "/new/hello.gz")
+		fatalfIfErr(t, err, "failed to rename replace")
+		assertContent(t, "Hello, Foo!", v, "/new/hello.gz")
+		assertTmpDirFileCount(t, 1, tmp, "replaced files should be removed")
+
+		//------------ Parent isnt a dir
+		err = createFile(v, "/plain", 0640, time1, "Hello, Bar!")
+		fatalfIfErr(t, err, "failed to create /plain")
+		err = v.Rename("/new/hello.gz", "/plain/hello.gz")
+		assertErr(t, ErrNotDir, err, "should error if parent is a file")
+		assertContent(t, "Hello, Bar!", v, "/plain")
+		err = v.Rename("/new/hello.gz", "/plain/sub/hello.gz")
+		assertErr(t, ErrNotDir, err, "should error if a parent further up is a file")
+		assertContent(t, "Hello, Bar!", v, "/plain")
+		assertContent(t, "Hello, Foo!", v, "/new/hello.gz")
+
+		//------------ Into itself through a hardlink
+		_, err = v.MkdirP("/dir", 0755, time1)
+		fatalfIfErr(t, err, "failed to create /dir")
+		_, err = v.Hardlink("/dir", "/linked", 0755, time1)
+		fatalfIfErr(t, err, "failed to hardlink /dir")
+		err = v.Rename("/dir", "/linked/more/dir")
+		assertErr(t, ErrMoveIntoItself, err, "should error moving into itself through a hardlink")
+		_, err = v.Stat("/dir/more")
+		assertErr(t, ErrNotFound, err, "shouldnt create parents moving into itself")
+	})
+}
@@ -1,5 +1,17 @@
 package virtualfs
 
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrTagTypeRegistered the name or type is already registered (see RegisterTagType)
+var ErrTagTypeRegistered = fmt.Errorf("tag type already registered")
+
 // FsError returns an error if the filesystem has an error
 func (v *Fs) FsError() error {
 	if v.db.err {
@@ -16,21 +28,50 @@ func (v *Fs) FsWarning() error {
 	return nil
 }
 
-// Error sets the error for the Fs
+// Error adds an error to the Fs, a *Diagnostic (or an error wrapping one) keeps its code, extractor,
+// etc otherwise its code is the first error registered that err matches (see RegisterDiagnosticCode)
 func (n *Fs) Error(err error) {
-	n.ref.err = err
-	n.db.err = true
+	n.addDiagnostic(newDiagnostic(SeverityError, err))
 }
 
-// Warning adds a warning to the Fs
+// Warning adds a warning to the Fs (see Error)
 func (n *Fs) Warning(warn error) {
-	n.ref.warn = append(n.ref.warn, warn)
-	n.db.warn = true
+	n.addDiagnostic(newDiagnostic(SeverityWarning, warn))
+}
+
+// Errors returns the errors of the Fs
+func (n *Fs) Errors() []*Diagnostic {
+	return slices.Clone(n.ref.errs)
+}
+
+// Warnings returns the warnings of the Fs
+func (n *Fs) Warnings() []*Diagnostic {
+	return slices.Clone(n.ref.warns)
 }
 
-// TagS sets the tag with the given key to the given value
+func (n *Fs) addDiagnostic(d *Diagnostic) {
+	op := journalWarning
+	if d.Severity == SeverityError {
+		n.ref.errs = append(n.ref.errs, d)
+		n.db.err = true
+		op = journalError
+	} else {
+		n.ref.warns = append(n.ref.warns, d)
+		n.db.warn = true
+	}
+	data := toJsonDiagnostic(d)
+	// the append error is sticky so a change that isnt durable is returned by the next change that
+	// returns errors and makes the journal due (see journal.append)
+	_ = n.db.record(journalRecord{Op: op, Id: n.ref.id, Diagnostic: &data})
+}
+
+// TagS sets the tag with the given key to the given value, values load as the same type
+// if its a string, bool, number, time.Time, []byte, []string, map[string]any or []any (of those)
+// or registered (see RegisterTagType). Other values load as they marshal to json (i.e. map[string]any)
 func (n *Fs) TagS(key string, value any) {
 	n.ref.tags.Store(key, value)
+	// see addDiagnostic
+	_ = n.db.record(journalRecord{Op: journalTag, Id: n.ref.id, Key: key, Tag: &tagValue{value}})
 }
 
 // TagSIfBlank sets the tag with the given key to the given value if it is not already set
@@ -40,7 +81,7 @@ func (n *Fs) TagSIfBlank(key string, value any) error {
 	if loaded {
 		return ErrAlreadyExist
 	}
-	return nil
+	return n.db.record(journalRecord{Op: journalTag, Id: n.ref.id, Key: key, Tag: &tagValue{value}})
 }
 
 // TagG returns the tag with the given key, return true if it exists, false if it does not
@@ -50,5 +91,175 @@ func (n *Fs) TagG(key string) (any, bool) {
 
 // TagD deletes the tag with the given key, returns the tag
 func (n *Fs) TagD(key string) (any, bool) {
-	return n.ref.tags.LoadAndDelete(key)
+	value, loaded := n.ref.tags.LoadAndDelete(key)
+	if loaded {
+		// see addDiagnostic
+		_ = n.db.record(journalRecord{Op: journalUntag, Id: n.ref.id, Key: key})
+	}
+	return value, loaded
+}
+
+// ------------- tag types ------------------
+// tagTypes are the types tag values are saved as by name, nil, map[string]any and []any are saved as
+// null, map and list (with the type of their values) and anything else as json (loaded as any)
+var tagTypes = struct {
+	mu     sync.RWMutex
+	byName map[string]reflect.Type
+	names  map[reflect.Type]string
+}{byName: make(map[string]reflect.Type), names: make(map[reflect.Type]string)}
+
+func init() {
+	for name, value := range map[string]any{
+		"string": "", "bool": false, "time": time.Time{}, "bytes": []byte{}, "strings": []string{},
+		"int": int(0), "int8": int8(0), "int16": int16(0), "int32": int32(0), "int64": int64(0),
+		"uint": uint(0), "uint8": uint8(0), "uint16": uint16(0), "uint32": uint32(0), "uint64": uint64(0),
+		"float32": float32(0), "float64": float64(0),
+	} {
+		tagTypes.byName[name] = reflect.TypeOf(value)
+		tagTypes.names[reflect.TypeOf(value)] = name
+	}
+	for _, name := range []string{"null", "map", "list", "json"} {
+		tagTypes.byName[name] = nil
+	}
+}
+
+// RegisterTagType saves tags with the type of value (i.e. a struct) as name so they load as that type
+// instead of how they marshal to json. Registering the same name and type again does nothing
+func RegisterTagType(name string, value any) error {
+	typ := reflect.TypeOf(value)
+	tagTypes.mu.Lock()
+	defer tagTypes.mu.Unlock()
+
+	registered, nameUsed := tagTypes.byName[name]
+	current, typeUsed := tagTypes.names[typ]
+	if nameUsed && typeUsed && registered == typ && current == name {
+		return nil
+	}
+	if nameUsed || typeUsed || typ == nil {
+		return fmt.Errorf("%w: %v (%v)", ErrTagTypeRegistered, name, typ)
+	}
+	tagTypes.byName[name] = typ
+	tagTypes.names[typ] = name
+	return nil
 }
+
+// tagValue is a tag value saved with its type ({"type": ..., "value": ...}) so it loads as the same type
+type tagValue struct {
+	value any
+}
+
+type jsonTagValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (t tagValue) MarshalJSON() ([]byte, error) {
+	var err error
+	data := jsonTagValue{}
+	switch value := t.value.(type) {
+	case nil:
+		data.Type = "null"
+	case map[string]any:
+		values := make(map[string]tagValue, len(value))
+		for k, v := range value {
+			values[k] = tagValue{v}
+		}
+		data.Type = "map"
+		data.Value, err = json.Marshal(values)
+	case []any:
+		values := make([]tagValue, 0, len(value))
+		for _, v := range value {
+			values = append(values, tagValue{v})
+		}
+		data.Type = "list"
+		data.Value, err = json.Marshal(values)
+	default:
+		tagTypes.mu.RLock()
+		name, ok := tagTypes.names[reflect.TypeOf(value)]
+		tagTypes.mu.RUnlock()
+		if !ok {
+			name = "json"
+		}
+		data.Type = name
+		data.Value, err = json.Marshal(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func (t *tagValue) UnmarshalJSON(b []byte) error {
+	data := jsonTagValue{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	switch data.Type {
+	case "null":
+		t.value = nil
+		return nil
+	case "map":
+		values := map[string]tagValue{}
+		if err := json.Unmarshal(data.Value, &values); err != nil {
+			return err
+		}
+		value := make(map[string]any, len(values))
+		for k, v := range values {
+			value[k] = v.value
+		}
+		t.value = value
+		return nil
+	case "list":
+		values := []tagValue{}
+		if err := json.Unmarshal(data.Value, &values); err != nil {
+			return err
+		}
+		value := make([]any, 0, len(values))
+		for _, v := range values {
+			value = append(value, v.value)
+		}
+		t.value = value
+		return nil
+	}
+
+	tagTypes.mu.RLock()
+	typ := tagTypes.byName[data.Type]
+	tagTypes.mu.RUnlock()
+	// types that arent registered (i.e. by another program) load as json
+	if typ == nil {
+		return json.Unmarshal(data.Value, &t.value)
+	}
+	value := reflect.New(typ)
+	if err := json.Unmarshal(data.Value, value.Interface()); err != nil {
+		return fmt.Errorf("error unmarshalling tag %v - %w", data.Type, err)
+	}
+	t.value = value.Elem().Interface()
+	return nil
+}
+
+// typeTags saves the tags of a format 1 jsonFs with a type (see tagValue and migrateBaseline).
+// The tags load as they did before (i.e. numbers are float64)
+func typeTags(line []byte) ([]byte, error) {
+	entry := map[string]json.RawMessage{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	key := "tags"
+	untyped := map[string]any{}
+	if raw, ok := entry[key]; !ok || string(raw) == "null" {
+		return line, nil
+	} else if err := json.Unmarshal(raw, &untyped); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]tagValue, len(untyped))
+	for k, v := range untyped {
+		tags[k] = tagValue{v}
+	}
+	var err error
+	if entry[key], err = json.Marshal(tags); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entry)
+}
+
+// ------------- tag types ------------------
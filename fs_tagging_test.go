@@ -0,0 +1,120 @@
+package virtualfs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testTag struct {
+	Name  string
+	Count int
+}
+
+func init() {
+	if err := RegisterTagType("virtualfs.testTag", testTag{}); err != nil {
+		panic(err)
+	}
+}
+
+// testTags are tags of each type that should load as the same type
+var testTags = map[string]any{
+	"string":  "bar",
+	"bool":    true,
+	"int":     42,
+	"int64":   int64(-1 << 40),
+	"uint8":   uint8(7),
+	"float32": float32(1.5),
+	"float64": 2.25,
+	"time":    time1.UTC(),
+	"bytes":   []byte{0, 1, 2},
+	"strings": []string{"a", "b"},
+	"null":    nil,
+	"map":     map[string]any{"count": 3, "at": time2.UTC(), "nested": map[string]any{"ok": true}},
+	"list":    []any{1, "two", 3.5},
+	"struct":  testTag{Name: "foo", Count: 2},
+}
+
+func TestTagTypes(t *testing.T) {
+	for name, opts := range map[string][]Option{"json": nil, "compact": {WithCompactDB()}, "sqlite": {WithSQLite()}} {
+		tmpDir(t, func(tmp string) {
+			v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, opts...)
+			fatalfIfErr(t, err, "%v: failed to create virtual function", name)
+			for key, value := range testTags {
+				v.TagS(key, value)
+			}
+			fatalfIfErr(t, v.Close(), "%v: failed to close", name)
+
+			v, err = NewFsFromDb(tmp)
+			fatalfIfErr(t, err, "%v: failed to load", name)
+			for key, value := range testTags {
+				loaded := mustTag(t, v, key)
+				assert(t, reflect.DeepEqual(value, loaded), "%v: tag %v should be %#v not %#v", name, key, value, loaded)
+			}
+		})
+	}
+}
+
+func TestTagTypesJournal(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		v.TagS("count", 3)
+		fatalfIfErr(t, v.TagSIfBlank("struct", testTag{Name: "foo"}), "failed to tag if blank")
+
+		//------------ Crash (no Close) and replay
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertEqual(t, any(3), mustTag(t, v, "count"), "replayed tag should be an int")
+		assertEqual(t, any(testTag{Name: "foo"}), mustTag(t, v, "struct"), "replayed tag should be registered type")
+	})
+}
+
+func TestTagTypesOlderFormat(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		root, err := json.Marshal(toJsonFs("/", false, v))
+		fatalfIfErr(t, err, "failed to marshal root")
+
+		//------------ Tags saved before they were typed (format 1) load as they did
+		untyped := strings.Replace(string(root), `"tags":{}`, `"tags":{"count":3,"name":"foo"}`, 1)
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, finDB), []byte(untyped+"\n"), 0644), "failed to write format 1")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load format 1")
+		assertEqual(t, any(float64(3)), mustTag(t, v, "count"), "untyped number should load as float64")
+		assertEqual(t, any("foo"), mustTag(t, v, "name"), "untyped string should load")
+
+		//------------ And are typed when saved again
+		v.TagS("other", 1)
+		fatalfIfErr(t, v.Close(), "failed to close")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load again")
+		assertEqual(t, any(float64(3)), mustTag(t, v, "count"), "migrated tag should stay a float64")
+		assertEqual(t, any(1), mustTag(t, v, "other"), "new tag should be an int")
+	})
+}
+
+func TestTagTypesUnregistered(t *testing.T) {
+	value := tagValue{}
+	err := json.Unmarshal([]byte(`{"type":"other.Type","value":{"count":1}}`), &value)
+	fatalfIfErr(t, err, "failed to unmarshal unknown type")
+	assert(t, reflect.DeepEqual(map[string]any{"count": float64(1)}, value.value), "unknown type should load as json: %#v", value.value)
+
+	type unregistered struct{ Count int }
+	b, err := json.Marshal(tagValue{unregistered{1}})
+	fatalfIfErr(t, err, "failed to marshal unregistered type")
+	assertEqual(t, `{"type":"json","value":{"Count":1}}`, string(b), "unregistered type should save as json")
+}
+
+func TestRegisterTagType(t *testing.T) {
+	fatalfIfErr(t, RegisterTagType("virtualfs.testTag", testTag{}), "registering again should do nothing")
+	assertErr(t, ErrTagTypeRegistered, RegisterTagType("virtualfs.other", testTag{}), "type is already registered")
+	assertErr(t, ErrTagTypeRegistered, RegisterTagType("virtualfs.testTag", struct{}{}), "name is already registered")
+	assertErr(t, ErrTagTypeRegistered, RegisterTagType("int", struct{ A int }{}), "built in names cant be registered")
+	assertErr(t, ErrTagTypeRegistered, RegisterTagType("map", struct{ B int }{}), "special names cant be registered")
+	assertErr(t, ErrTagTypeRegistered, RegisterTagType("nil", nil), "nil cant be registered")
+}
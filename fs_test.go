@@ -536,3 +536,54 @@ func TestVirtaulWithDir(t *testing.T) {
 		assertTmpDirFileCount(t, 0, tmp, "comparing")
 	})
 }
+
+func TestSection(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newFooFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/archive", 0655, time1, "Hello, World!Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create virtual file /archive")
+		archive, err := v.Stat("/archive")
+		fatalfIfErr(t, err, "failed to get /archive")
+
+		hello, err := archive.Create("/hello", 0600, time2)
+		fatalfIfErr(t, err, "failed to create /archive/hello")
+		err = hello.CreateSection(archive, 0, 13)
+		fatalfIfErr(t, err, "failed to create section /archive/hello")
+
+		foo, err := archive.Create("/foo", 0600, time3)
+		fatalfIfErr(t, err, "failed to create /archive/foo")
+		err = foo.CreateSection(archive, 13, 11)
+		fatalfIfErr(t, err, "failed to create section /archive/foo")
+
+		bad, err := archive.Create("/bad", 0600, time3)
+		fatalfIfErr(t, err, "failed to create /archive/bad")
+		err = bad.CreateSection(archive, 13, 12)
+		assertErr(t, ErrOutOfRange, err, "should error if section is past the end")
+		err = archive.CreateSection(archive, 0, 13)
+		assertErr(t, ErrCircularReference, err, "should error if section is of itself")
+		err = archive.CreateSection(hello, 0, 13)
+		assertErr(t, ErrCircularReference, err, "should error if section is of a section of itself")
+
+		assertEqual(t, helloWorldSha512, hello.Sha512(), "section should be hashed")
+		assertEqual(t, int64(13), hello.Size(), "section should have its size")
+		assertEqual(t, "", hello.FilePath(), "section shouldnt have a file path")
+		assertTmpDirFileCount(t, 2, tmp, "sections shouldnt be stored")
+		assertContent(t, "Hello, World!", v, "/archive/hello")
+		assertContent(t, "Hello, Foo!", v, "/archive/foo")
+		_, err = hello.OpenFile()
+		assertErr(t, ErrNotOnDisk, err, "section cant be opened as an os.File")
+		file, err := v.Open("/archive")
+		fatalfIfErr(t, err, "stored file should open as an os.File")
+		assertEqual(t, archive.FilePath(), file.Name(), "should open the stored file")
+		file.Close()
+
+		//------------ Close and reload
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertContent(t, "Hello, World!", newV, "/archive/hello")
+		assertContent(t, "Hello, Foo!", newV, "/archive/foo")
+	})
+}
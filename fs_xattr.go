@@ -0,0 +1,272 @@
+package virtualfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// xattr names with decoded views
+const (
+	XattrCapability = "security.capability"
+	XattrSELinux    = "security.selinux"
+	XattrACLAccess  = "system.posix_acl_access"
+	XattrACLDefault = "system.posix_acl_default"
+)
+
+// paxXattrPrefix is how tar stores xattrs in PAX records
+const paxXattrPrefix = "SCHILY.xattr."
+
+// Setxattr sets the extended attribute name of the entry at path (see Chmod)
+func (v *Fs) Setxattr(path, name string, value []byte) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("%w: blank name", ErrNoXattr)
+	}
+
+	if n.xattrs == nil {
+		n.xattrs = make(map[string][]byte)
+	}
+	n.xattrs[name] = slices.Clone(value)
+	return v.journalAttr(path)
+}
+
+// Getxattr returns the extended attribute name of the entry at path, ErrNoXattr if not set
+func (v *Fs) Getxattr(path, name string) ([]byte, error) {
+	n, err := v.location(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := n.xattrs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoXattr, name)
+	}
+	return slices.Clone(value), nil
+}
+
+// Listxattr returns the extended attribute names (sorted) of the entry at path
+func (v *Fs) Listxattr(path string) ([]string, error) {
+	n, err := v.location(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(n.xattrs))
+	for name := range n.xattrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Removexattr removes the extended attribute name of the entry at path, ErrNoXattr if not set
+func (v *Fs) Removexattr(path, name string) error {
+	n, err := v.location(path)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := n.xattrs[name]; !ok {
+		return fmt.Errorf("%w: %v", ErrNoXattr, name)
+	}
+	delete(n.xattrs, name)
+	return v.journalAttr(path)
+}
+
+// XattrsFromPAX returns the extended attributes in tar PAX records (SCHILY.xattr.*)
+// so extractors can pass them to Setxattr
+func XattrsFromPAX(records map[string]string) map[string][]byte {
+	xattrs := make(map[string][]byte)
+	for key, value := range records {
+		if name, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+			xattrs[name] = []byte(value)
+		}
+	}
+	return xattrs
+}
+
+// ----------------Capabilities--------------------
+// FileCapabilities is the decoded security.capability xattr (see capabilities(7))
+type FileCapabilities struct {
+	Version     int
+	Effective   bool
+	Permitted   uint64
+	Inheritable uint64
+	// RootId is the user namespace root (version 3 only)
+	RootId uint32
+}
+
+// vfs_cap_data revisions
+const (
+	capRevisionMask = 0xff000000
+	capRevision1    = 0x01000000
+	capRevision2    = 0x02000000
+	capRevision3    = 0x03000000
+	capEffective    = 0x000001
+)
+
+// capNames are the capability names by number (linux/capability.h)
+var capNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner", "cap_fsetid",
+	"cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap", "cap_linux_immutable",
+	"cap_net_bind_service", "cap_net_broadcast", "cap_net_admin", "cap_net_raw", "cap_ipc_lock",
+	"cap_ipc_owner", "cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice", "cap_sys_resource",
+	"cap_sys_time", "cap_sys_tty_config", "cap_mknod", "cap_lease", "cap_audit_write",
+	"cap_audit_control", "cap_setfcap", "cap_mac_override", "cap_mac_admin", "cap_syslog",
+	"cap_wake_alarm", "cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Capabilities returns the decoded file capabilities of the entry at path, ErrNoXattr if not set
+func (v *Fs) Capabilities(path string) (*FileCapabilities, error) {
+	value, err := v.Getxattr(path, XattrCapability)
+	if err != nil {
+		return nil, err
+	}
+	return parseCapabilities(value)
+}
+
+func parseCapabilities(value []byte) (*FileCapabilities, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("capabilities too short %v", len(value))
+	}
+	magic := binary.LittleEndian.Uint32(value)
+	caps := &FileCapabilities{Effective: magic&capEffective != 0}
+
+	size := 0
+	switch magic & capRevisionMask {
+	case capRevision1:
+		caps.Version, size = 1, 12
+	case capRevision2:
+		caps.Version, size = 2, 20
+	case capRevision3:
+		caps.Version, size = 3, 24
+	default:
+		return nil, fmt.Errorf("unknown capabilities revision %#x", magic&capRevisionMask)
+	}
+	if len(value) < size {
+		return nil, fmt.Errorf("capabilities version %v too short %v", caps.Version, len(value))
+	}
+
+	caps.Permitted = uint64(binary.LittleEndian.Uint32(value[4:]))
+	caps.Inheritable = uint64(binary.LittleEndian.Uint32(value[8:]))
+	if caps.Version > 1 {
+		caps.Permitted |= uint64(binary.LittleEndian.Uint32(value[12:])) << 32
+		caps.Inheritable |= uint64(binary.LittleEndian.Uint32(value[16:])) << 32
+	}
+	if caps.Version == 3 {
+		caps.RootId = binary.LittleEndian.Uint32(value[20:])
+	}
+	return caps, nil
+}
+
+// CapabilityNames returns the capability names in the set (i.e. Permitted)
+func CapabilityNames(set uint64) []string {
+	names := []string{}
+	for i := range 64 {
+		if set&(1<<i) != 0 {
+			names = append(names, capabilityName(i))
+		}
+	}
+	return names
+}
+
+func capabilityName(i int) string {
+	if i < len(capNames) {
+		return capNames[i]
+	}
+	return fmt.Sprintf("cap_%v", i)
+}
+
+// String returns the capabilities like getcap (i.e. "cap_net_raw+ep")
+func (c *FileCapabilities) String() string {
+	parts := []string{}
+	for i := range 64 {
+		bit := uint64(1) << i
+		if (c.Permitted|c.Inheritable)&bit == 0 {
+			continue
+		}
+		flags := ""
+		if c.Effective && c.Permitted&bit != 0 {
+			flags += "e"
+		}
+		if c.Inheritable&bit != 0 {
+			flags += "i"
+		}
+		if c.Permitted&bit != 0 {
+			flags += "p"
+		}
+		parts = append(parts, capabilityName(i)+"+"+flags)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ----------------Capabilities--------------------
+
+// ----------------ACLs--------------------
+// ACLTag is the type of an ACL entry (see acl(5))
+type ACLTag uint16
+
+const (
+	ACLUserObj  ACLTag = 0x01
+	ACLUser     ACLTag = 0x02
+	ACLGroupObj ACLTag = 0x04
+	ACLGroup    ACLTag = 0x08
+	ACLMask     ACLTag = 0x10
+	ACLOther    ACLTag = 0x20
+)
+
+// ACLEntry is an entry of a POSIX ACL, Id is the uid or gid for ACLUser and ACLGroup
+type ACLEntry struct {
+	Tag  ACLTag
+	Perm uint16
+	Id   uint32
+}
+
+const aclVersion = 2
+const aclEntrySize = 8
+
+// ACL returns the decoded POSIX access ACL of the entry at path, ErrNoXattr if not set
+func (v *Fs) ACL(path string) ([]ACLEntry, error) {
+	value, err := v.Getxattr(path, XattrACLAccess)
+	if err != nil {
+		return nil, err
+	}
+	return parseACL(value)
+}
+
+// DefaultACL returns the decoded POSIX default ACL of the dir at path, ErrNoXattr if not set
+func (v *Fs) DefaultACL(path string) ([]ACLEntry, error) {
+	value, err := v.Getxattr(path, XattrACLDefault)
+	if err != nil {
+		return nil, err
+	}
+	return parseACL(value)
+}
+
+func parseACL(value []byte) ([]ACLEntry, error) {
+	if len(value) < 4 || (len(value)-4)%aclEntrySize != 0 {
+		return nil, fmt.Errorf("acl is corrupt")
+	}
+	if version := binary.LittleEndian.Uint32(value); version != aclVersion {
+		return nil, fmt.Errorf("unknown acl version %v", version)
+	}
+
+	entries := []ACLEntry{}
+	for entry := range slices.Chunk(value[4:], aclEntrySize) {
+		entries = append(entries, ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(entry)),
+			Perm: binary.LittleEndian.Uint16(entry[2:]),
+			Id:   binary.LittleEndian.Uint32(entry[4:]),
+		})
+	}
+	return entries, nil
+}
+
+// ----------------ACLs--------------------
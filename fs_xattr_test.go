@@ -0,0 +1,70 @@
+package virtualfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestXattr(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/bin/ping", 0755, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /bin/ping")
+
+		// version 2, effective, cap_net_bind_service and cap_net_raw permitted
+		caps := binary.LittleEndian.AppendUint32(nil, capRevision2|capEffective)
+		caps = binary.LittleEndian.AppendUint32(caps, 1<<10|1<<13)
+		caps = append(caps, make([]byte, 12)...)
+		fatalfIfErr(t, v.Setxattr("/bin/ping", XattrCapability, caps), "failed to set capability")
+		fatalfIfErr(t, v.Setxattr("/bin/ping", XattrSELinux, []byte("system_u:object_r:ping_exec_t:s0\x00")), "failed to set selinux")
+		fatalfIfErr(t, v.Setxattr("/bin/ping", "user.remove", []byte("me")), "failed to set user.remove")
+
+		// user::rwx group::r-x user 1000:rw- mask::rwx other::r--
+		acl := binary.LittleEndian.AppendUint32(nil, aclVersion)
+		for _, e := range []ACLEntry{{ACLUserObj, 7, 0xffffffff}, {ACLUser, 6, 1000}, {ACLGroupObj, 5, 0xffffffff}, {ACLMask, 7, 0xffffffff}, {ACLOther, 4, 0xffffffff}} {
+			acl = binary.LittleEndian.AppendUint16(acl, uint16(e.Tag))
+			acl = binary.LittleEndian.AppendUint16(acl, e.Perm)
+			acl = binary.LittleEndian.AppendUint32(acl, e.Id)
+		}
+		fatalfIfErr(t, v.Setxattr("/bin", XattrACLAccess, acl), "failed to set acl")
+
+		fatalfIfErr(t, v.Removexattr("/bin/ping", "user.remove"), "failed to remove user.remove")
+		err = v.Removexattr("/bin/ping", "user.remove")
+		assertErr(t, ErrNoXattr, err, "should error removing xattr that doesnt exist")
+		_, err = v.Getxattr("/bin", XattrCapability)
+		assertErr(t, ErrNoXattr, err, "should error getting xattr that doesnt exist")
+
+		assertXattrs := func(v *Fs, str string) {
+			t.Helper()
+			names, err := v.Listxattr("/bin/ping")
+			fatalfIfErr(t, err, "%v failed to list xattrs", str)
+			assertEqual(t, 2, len(names), "%v expected capability and selinux", str)
+			assertEqual(t, XattrCapability, names[0], "%v expected capability", str)
+
+			fc, err := v.Capabilities("/bin/ping")
+			fatalfIfErr(t, err, "%v failed to get capabilities", str)
+			assertEqual(t, 2, fc.Version, "%v version doesnt match", str)
+			assertEqual(t, "cap_net_bind_service+ep cap_net_raw+ep", fc.String(), "%v capabilities dont match", str)
+
+			entries, err := v.ACL("/bin")
+			fatalfIfErr(t, err, "%v failed to get acl", str)
+			assertEqual(t, 5, len(entries), "%v expected 5 acl entries", str)
+			assertEqual(t, ACLEntry{ACLUser, 6, 1000}, entries[1], "%v user entry doesnt match", str)
+		}
+		assertXattrs(v, "before closing")
+
+		buf := &bytes.Buffer{}
+		fatalfIfErr(t, v.ExportTar("/bin/ping", buf), "failed to export tar")
+		hdr, err := tar.NewReader(buf).Next()
+		fatalfIfErr(t, err, "failed to read tar")
+		assertEqual(t, string(caps), string(XattrsFromPAX(hdr.PAXRecords)[XattrCapability]), "tar should have capability")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertXattrs(newV, "after loading")
+	})
+}
@@ -249,3 +249,18 @@ func tmpDir(t *testing.T, fnc func(tmp string)) {
 }
 
 // --------------------------Tmp Dir------------------
+
+// assertDuplicateStoredOnce writes the same small file twice with opts and checks only one blob is stored
+func assertDuplicateStoredOnce(t *testing.T, opts ...Option) {
+	t.Helper()
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, opts...)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/hello", 0644, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /hello")
+		err = createFile(v, "/duplicate", 0644, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /duplicate")
+		assertTmpDirFileCount(t, 1, tmp, "duplicate shouldnt be stored")
+		assertContent(t, "Hello, World!", v, "/duplicate")
+	})
+}
@@ -0,0 +1,261 @@
+package virtualfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ImportOption configures ImportDir
+type ImportOption func(*importOptions)
+
+type importOptions struct {
+	include []string
+	exclude []string
+	workers int
+}
+
+func newImportOptions(opts []ImportOption) *importOptions {
+	o := &importOptions{workers: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ImportInclude only imports files, symlinks and devices matching one of the patterns (see path.Match),
+// dirs are still walked. A pattern without a "/" matches the file name, otherwise the path relative
+// to the imported dir (i.e. "etc/*.conf")
+func ImportInclude(patterns ...string) ImportOption {
+	return func(o *importOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// ImportExclude skips files and dirs matching one of the patterns (see ImportInclude)
+func ImportExclude(patterns ...string) ImportOption {
+	return func(o *importOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// ImportWorkers sets how many files are copied (and hashed) at once, default is the number of cpus
+func ImportWorkers(workers int) ImportOption {
+	return func(o *importOptions) {
+		o.workers = max(workers, 1)
+	}
+}
+
+// importJob is a file to copy into node
+type importJob struct {
+	node *Fs
+	path string
+}
+
+// importLink is a file that is a hardlink to a file already imported
+type importLink struct {
+	source   string
+	path     string
+	hostPath string
+	info     fs.FileInfo
+}
+
+// ImportDir walks the dir hostPath on disk and creates its contents in the Fs, recreating dirs,
+// files, symlinks (which arent followed), hardlinks (by inode), devices, fifos, sockets, owners,
+// modes, mod times and extended attributes. Other file types are skipped with a warning
+func (v *Fs) ImportDir(hostPath string, opts ...ImportOption) error {
+	err := v.isClosed()
+	if err != nil {
+		return err
+	}
+
+	o := newImportOptions(opts)
+	for _, pattern := range append(o.include, o.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %q", err, pattern)
+		}
+	}
+	info, err := os.Lstat(hostPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", hostPath)
+	}
+
+	jobs := make(chan importJob)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var jobErr error
+	for range o.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := importFile(job.node, job.path); err != nil {
+					mu.Lock()
+					if jobErr == nil {
+						jobErr = fmt.Errorf("error importing %v - %w", job.path, err)
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	inodes := make(map[any]string)
+	links := []importLink{}
+	err = filepath.WalkDir(hostPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(hostPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if matchAny(o.exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && len(o.include) > 0 && !matchAny(o.include, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			node, err := v.MkdirP(rel, info.Mode(), info.ModTime())
+			if err != nil {
+				return err
+			}
+			setOwner(node, info)
+			node.xattrs, err = readXattrs(p)
+			if err != nil {
+				return err
+			}
+			return v.journalAttr(rel)
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			node, err := v.Symlink(target, rel, info.Mode(), info.ModTime())
+			if err != nil {
+				return err
+			}
+			setOwner(node, info)
+			return v.journalAttr(rel)
+		case info.Mode().IsRegular():
+			// hardlinks are created once all the files are copied (the reference can change if its a duplicate)
+			if id, ok := fileID(info); ok {
+				if source, seen := inodes[id]; seen {
+					links = append(links, importLink{source: source, path: rel, hostPath: p, info: info})
+					return nil
+				}
+				inodes[id] = rel
+			}
+			node, err := v.Create(rel, info.Mode(), info.ModTime())
+			if err != nil {
+				return err
+			}
+			setOwner(node, info)
+			node.xattrs, err = readXattrs(p)
+			if err != nil {
+				return err
+			}
+			if err := v.journalAttr(rel); err != nil {
+				return err
+			}
+			jobs <- importJob{node: node, path: p}
+			return nil
+		default:
+			major, minor := deviceNumbers(info)
+			node, err := v.Mknod(rel, info.Mode(), major, minor, info.ModTime())
+			if errors.Is(err, ErrUnsupportedType) {
+				v.Warning(fmt.Errorf("%w: %v (%v)", ErrUnsupportedType, rel, info.Mode().Type()))
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			setOwner(node, info)
+			return v.journalAttr(rel)
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if jobErr != nil {
+		return jobErr
+	}
+
+	for _, link := range links {
+		node, err := v.Hardlink(link.source, link.path, link.info.Mode(), link.info.ModTime())
+		if err != nil {
+			return err
+		}
+		setOwner(node, link.info)
+		node.xattrs, err = readXattrs(link.hostPath)
+		if err != nil {
+			return err
+		}
+		if err := v.journalAttr(link.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ----------------Helpers--------------------
+// importFile copies the file at path on disk into node
+func importFile(node *Fs, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, err := node.CreateFile()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// matchAny returns true if rel matches any of the patterns (see ImportInclude)
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		toMatch := rel
+		if !strings.Contains(pattern, "/") {
+			toMatch = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, toMatch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------Helpers--------------------
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package virtualfs
+
+import "io/fs"
+
+// fileID hardlinks arent detected so always false
+func fileID(info fs.FileInfo) (any, bool) {
+	return nil, false
+}
+
+// setOwner owner isnt known so nothing to set
+func setOwner(n *Fs, info fs.FileInfo) {}
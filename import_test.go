@@ -0,0 +1,59 @@
+package virtualfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportDir(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		host := filepath.Join(filepath.Dir(tmp), "host")
+		fatalfIfErr(t, os.MkdirAll(filepath.Join(host, "etc/skip"), 0750), "failed to create host dirs")
+		fatalfIfErr(t, os.WriteFile(filepath.Join(host, "etc/hello"), []byte("Hello, World!"), 0640), "failed to write hello")
+		fatalfIfErr(t, os.WriteFile(filepath.Join(host, "etc/skip/foo"), []byte("Hello, Foo!"), 0640), "failed to write foo")
+		fatalfIfErr(t, os.WriteFile(filepath.Join(host, "etc/other.tmp"), []byte("Hello, Foo!"), 0640), "failed to write other")
+		fatalfIfErr(t, os.Link(filepath.Join(host, "etc/hello"), filepath.Join(host, "linked")), "failed to hardlink")
+		fatalfIfErr(t, os.Symlink("/etc", filepath.Join(host, "sym")), "failed to symlink")
+		fatalfIfErr(t, os.Chtimes(filepath.Join(host, "etc/hello"), time1, time1), "failed to set time")
+
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = v.ImportDir(host, ImportExclude("skip", "*.tmp"), ImportWorkers(2))
+		fatalfIfErr(t, err, "failed to import dir")
+
+		assertContent(t, "Hello, World!", v, "/etc/hello")
+		assertContent(t, "Hello, World!", v, "/linked")
+		hello, err := v.Stat("/etc/hello")
+		fatalfIfErr(t, err, "failed to stat /etc/hello")
+		assertEqual(t, os.FileMode(0640), hello.Mode(), "mode should match")
+		assert(t, hello.ModTime().Equal(time1), "mod time should match %v", hello.ModTime())
+
+		sym, err := v.Stat("/sym")
+		fatalfIfErr(t, err, "failed to stat /sym")
+		assertEqual(t, "/etc", sym.symlinkPath, "symlink shouldnt be followed")
+
+		_, err = v.Stat("/etc/skip")
+		assertErr(t, ErrNotFound, err, "excluded dir shouldnt be imported")
+		_, err = v.Stat("/etc/other.tmp")
+		assertErr(t, ErrNotFound, err, "excluded file shouldnt be imported")
+
+		//------------ Include applies to symlinks too
+		included, err := newFooMemFs()
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = included.ImportDir(host, ImportInclude("hello"))
+		fatalfIfErr(t, err, "failed to import dir with include")
+		assertContent(t, "Hello, World!", included, "/etc/hello")
+		_, err = included.Stat("/sym")
+		assertErr(t, ErrNotFound, err, "symlink not included shouldnt be imported")
+		_, err = included.Stat("/linked")
+		assertErr(t, ErrNotFound, err, "file not included shouldnt be imported")
+		_, err = included.Stat("/etc/skip")
+		fatalfIfErr(t, err, "dirs should still be walked")
+
+		err = v.ImportDir(filepath.Join(host, "etc/hello"))
+		assert(t, err != nil, "should error importing a file")
+		err = v.ImportDir(host, ImportInclude("["))
+		assert(t, err != nil, "should error on bad pattern")
+	})
+}
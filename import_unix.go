@@ -0,0 +1,30 @@
+//go:build unix
+
+package virtualfs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// inode identifies a file on disk
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileID returns the inode of the file if it has other hardlinks
+func fileID(info fs.FileInfo) (any, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return nil, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
+
+// setOwner sets the owner of n from the file on disk
+func setOwner(n *Fs, info fs.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		n.uid, n.gid = int(stat.Uid), int(stat.Gid)
+	}
+}
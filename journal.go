@@ -0,0 +1,479 @@
+package virtualfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrCorruptJournal = fmt.Errorf("journal is corrupt")
+var ErrJournalUnsupported = fmt.Errorf("journal needs a storage dir")
+
+// ErrJournalEncrypted the journal is written as changes happen so it cant be encrypted like fin.db
+var ErrJournalEncrypted = fmt.Errorf("journal cant be used with encryption")
+
+// ErrJournalLocked another process is writing the journal, load without WithJournal to read it
+// (it cant be saved while the other process is journaling)
+var ErrJournalLocked = fmt.Errorf("journal is locked by another process")
+
+// fin.journal is locked (see lockJournal) by the process that writes it so only one process can journal a storage dir,
+// others can load it without WithJournal but cant save it while its journaled (see checkJournal). Records are
+// appended as changes happen and compacted into fin.db by Checkpoint or Close (see JournalDue). It needs a
+// storage dir and cant be used with WithEncryption
+const finJournal = "fin.journal"
+
+// DefaultJournalCompaction is how many journal records are written before the journal
+// is due to be compacted into fin.db (see JournalDue)
+const DefaultJournalCompaction = 10000
+
+// journal ops, nodes are found by the id of their reference (or their parent's reference and name)
+// so a replay doesnt need paths
+const (
+	// base is the first record, the checksum of the snapshot (fin.db) the journal applies to
+	journalBase = "base"
+	// set adds a node to the children (or child) of a reference (create, mkdir, symlink, hardlink, mknod)
+	journalSet = "set"
+	// ref sets the contents (hashes, type, etc) of a reference once its file is closed
+	journalRef        = "ref"
+	journalTag        = "tag"
+	journalUntag      = "untag"
+	journalError      = "error"
+	journalWarning    = "warning"
+	journalUnset      = "unset"
+	journalUnsetChild = "unsetChild"
+	journalMove       = "move"
+	// attr sets the mode, times, owner and xattrs of a node
+	journalAttr = "attr"
+	// link marks the node at the path To (from a node of the reference Id) as hardlinked (see Hardlink)
+	journalLink = "link"
+)
+
+type journalRecord struct {
+	Op         string          `json:"op"`
+	Base       string          `json:"base,omitempty"`
+	Id         string          `json:"id,omitempty"`
+	Parent     string          `json:"parent,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Child      bool            `json:"child,omitempty"`
+	To         string          `json:"to,omitempty"`
+	NewName    string          `json:"newName,omitempty"`
+	Key        string          `json:"key,omitempty"`
+	Link       string          `json:"link,omitempty"`
+	Tag        *tagValue       `json:"tag,omitempty"`
+	Diagnostic *jsonDiagnostic `json:"diagnostic,omitempty"`
+	Node       *jsonFs         `json:"node,omitempty"`
+}
+
+// journal is an append only log of the changes to the tree since the last snapshot
+// so a tree can be recovered if the process dies before Close. Records are written
+// as they happen (not synced, so it survives the process being killed not the machine)
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	records int
+	every   int
+	err     error
+}
+
+// openJournal opens and locks (see lockJournal) the journal at path, it isnt changed until its
+// reset for the snapshot it applies to. Returns ErrJournalLocked if another process has it open
+func openJournal(path string, every int) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal - %w", err)
+	}
+	if err := lockJournal(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &journal{file: file, every: every}, nil
+}
+
+// reset empties the journal once the tree is saved in the snapshot with checksum
+func (j *journal) reset(checksum string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating journal - %w", err)
+	}
+	j.records, j.err = 0, nil
+	j.writeLocked(journalRecord{Op: journalBase, Base: checksum})
+	return j.err
+}
+
+// append writes a record, a nil journal (journaling disabled) does nothing. Returns the error if the
+// record (or one before it) wasnt written since the journal cant be replayed past it until its compacted
+func (j *journal) append(record journalRecord) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.writeLocked(record)
+	j.records++
+	return j.err
+}
+
+func (j *journal) writeLocked(record journalRecord) {
+	// once a write fails the journal cant be replayed past it so stop (see due)
+	if j.err != nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		j.err = fmt.Errorf("error marshalling journal record - %w", err)
+		return
+	}
+	// one write so a record is never interleaved with another
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		j.err = fmt.Errorf("error writing journal - %w", err)
+	}
+}
+
+// due returns true if the journal should be compacted into the snapshot
+func (j *journal) due() bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.records >= j.every || j.err != nil
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// ----------------Recording--------------------
+// journalFs returns the node info (not the contents) of n for a journal record
+func journalFs(n *Fs) *jsonFs {
+	return &jsonFs{
+		jsonLoc: toJsonLoc(false, n),
+		jsonRef: jsonRef{Uid: n.ref.id, Type: n.ref.typ},
+	}
+}
+
+// record journals a change to the tree returning an error if the change isnt durable (see journal.append).
+// Its never compacted here since other goroutines might be changing the tree (see JournalDue)
+func (rdb *referenceDB) record(record journalRecord) error {
+	return rdb.journal.append(record)
+}
+
+// JournalDue returns true if compactEvery records (see WithJournal) have been journaled or writing one
+// failed since the last Checkpoint, which compacts them into fin.db. Compacting is a full save so its only
+// done by Checkpoint and Close, call Checkpoint between changes (i.e. after each file extracted) once its due
+func (v *Fs) JournalDue() bool {
+	return v.db.journal.due()
+}
+
+// checkJournal returns ErrJournalLocked if another process is journaling the storage dir (see WithJournal)
+// so a process without the journal doesnt save over the snapshot the journal is for
+func (rdb *referenceDB) checkJournal() error {
+	if rdb.journal != nil || rdb.dbDir == "" {
+		return nil
+	}
+	file, err := os.OpenFile(filepath.Join(rdb.dbDir, finJournal), os.O_WRONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening journal - %w", err)
+	}
+	defer file.Close()
+	return lockJournal(file)
+}
+
+// journalSet records child being added to the children (or child) of parent
+func (rdb *referenceDB) journalSet(parent *reference, child *Fs, asChild bool) error {
+	if rdb.journal == nil {
+		return nil
+	}
+	return rdb.record(journalRecord{Op: journalSet, Parent: parent.id, Child: asChild, Node: journalFs(child)})
+}
+
+// journalAttr records the attributes of the entry at path (see location)
+func (v *Fs) journalAttr(path string) error {
+	if v.db.journal == nil {
+		return nil
+	}
+	paths, err := split(path)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return v.db.record(journalRecord{Op: journalAttr, Id: v.ref.id, Node: journalFs(v)})
+	}
+	parent, name, n, err := v.entry(path)
+	if err != nil {
+		return err
+	}
+	return v.db.record(journalRecord{Op: journalAttr, Parent: parent.ref.id, Name: name, Node: journalFs(n)})
+}
+
+// journalLink records the node at path (the source of a hardlink) being linked
+func (v *Fs) journalLink(path, link string) error {
+	return v.db.record(journalRecord{Op: journalLink, Id: v.ref.id, To: path, Link: link})
+}
+
+// ----------------Recording--------------------
+
+// ----------------Replay--------------------
+// journalReplay applies the changes in the journal to a tree loaded from a snapshot
+type journalReplay struct {
+	v *Fs
+	// references and (a) node for them by id
+	refs  map[string]*reference
+	nodes map[string]*Fs
+}
+
+// replayJournal applies the journal (if any) to the loaded tree, returning the number of records
+// applied. A journal for another snapshot is ignored and a corrupt record (i.e. the process
+// died writing it) stops the replay with a warning
+func (v *Fs) replayJournal() (int, error) {
+	if v.db.dbDir == "" {
+		return 0, nil
+	}
+	file, err := os.Open(filepath.Join(v.db.dbDir, finJournal))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error opening journal - %w", err)
+	}
+	defer file.Close()
+
+	sc := newDBScanner(file)
+	if !sc.Scan() {
+		return 0, sc.Err()
+	}
+	base := journalRecord{}
+	if err := json.Unmarshal(sc.Bytes(), &base); err != nil || base.Op != journalBase {
+		v.Warning(fmt.Errorf("%w: missing base", ErrCorruptJournal))
+		return 0, nil
+	}
+	if base.Base != v.db.checksum {
+		// already compacted into the snapshot
+		return 0, nil
+	}
+
+	r := &journalReplay{v: v, refs: make(map[string]*reference), nodes: make(map[string]*Fs)}
+	err = v.walkRecursive("/", false, func(path string, child bool, n *Fs) error {
+		r.refs[n.ref.id] = n.ref
+		if _, ok := r.nodes[n.ref.id]; !ok {
+			r.nodes[n.ref.id] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for sc.Scan() {
+		record := journalRecord{}
+		if err := json.Unmarshal(sc.Bytes(), &record); err != nil {
+			v.Warning(fmt.Errorf("%w: record %v - %v", ErrCorruptJournal, applied+1, err))
+			return applied, nil
+		}
+		if err := r.apply(record); err != nil {
+			v.Warning(fmt.Errorf("%w: record %v - %v", ErrCorruptJournal, applied+1, err))
+			return applied, nil
+		}
+		applied++
+	}
+	if err := sc.Err(); err != nil {
+		return applied, fmt.Errorf("error reading journal - %w", err)
+	}
+	return applied, nil
+}
+
+func (r *journalReplay) ref(id string) (*reference, error) {
+	ref, ok := r.refs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: reference %v", ErrNotFound, id)
+	}
+	return ref, nil
+}
+
+func (r *journalReplay) node(id string) (*Fs, error) {
+	n, ok := r.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: node %v", ErrNotFound, id)
+	}
+	return n, nil
+}
+
+// location returns the node for an attr record
+func (r *journalReplay) location(record journalRecord) (*Fs, error) {
+	if record.Parent == "" {
+		return r.node(record.Id)
+	}
+	parent, err := r.ref(record.Parent)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := parent.children[record.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, record.Name)
+	}
+	return n, nil
+}
+
+func (r *journalReplay) apply(record journalRecord) error {
+	if record.Node == nil && (record.Op == journalSet || record.Op == journalRef || record.Op == journalAttr) {
+		return fmt.Errorf("%v missing node", record.Op)
+	}
+
+	switch record.Op {
+	case journalSet:
+		parent, err := r.ref(record.Parent)
+		if err != nil {
+			return err
+		}
+		n := &Fs{db: r.v.db}
+		setFsFromJson(n, record.Node.jsonLoc)
+		if ref, ok := r.refs[record.Node.Uid]; ok {
+			// hardlink
+			n.ref = ref
+		} else {
+			n.ref = &reference{id: record.Node.Uid, typ: record.Node.Type, children: make(map[string]*Fs)}
+			r.refs[n.ref.id] = n.ref
+			r.nodes[n.ref.id] = n
+		}
+		if record.Child {
+			_, err = parent.setChild(n)
+		} else {
+			_, err = parent.setChildren(n)
+		}
+		return err
+	case journalRef:
+		n, err := r.node(record.Id)
+		if err != nil {
+			return err
+		}
+		data := record.Node
+		ref := n.ref
+		ref.size = data.Size
+		ref.md5 = data.MD5
+		ref.sha1 = data.SHA1
+		ref.sha256 = data.SHA256
+		ref.sha512 = data.SHA512
+		ref.entropy = data.Entropy
+		ref.typ = data.Type
+		ref.offset = data.Offset
+		if data.Source != "" {
+			if ref.source, err = r.ref(data.Source); err != nil {
+				return err
+			}
+			r.v.db.attach(ref.source)
+		}
+		if _, err := n.updateIfDuplicateRef(); err != nil {
+			return err
+		}
+		r.refs[n.ref.id] = n.ref
+		if _, ok := r.nodes[n.ref.id]; !ok {
+			r.nodes[n.ref.id] = n
+		}
+		return nil
+	case journalTag, journalUntag:
+		ref, err := r.ref(record.Id)
+		if err != nil {
+			return err
+		}
+		if record.Op == journalUntag {
+			ref.tags.Delete(record.Key)
+			return nil
+		}
+		if record.Tag == nil {
+			return fmt.Errorf("missing tag %v", record.Key)
+		}
+		ref.tags.Store(record.Key, record.Tag.value)
+		return nil
+	case journalError, journalWarning:
+		n, err := r.node(record.Id)
+		if err != nil {
+			return err
+		}
+		if record.Diagnostic == nil {
+			return fmt.Errorf("missing diagnostic")
+		}
+		n.addDiagnostic(record.Diagnostic.diagnostic())
+		return nil
+	case journalUnset:
+		parent, err := r.ref(record.Parent)
+		if err != nil {
+			return err
+		}
+		n, ok := parent.children[record.Name]
+		if !ok {
+			return nil
+		}
+		delete(parent.children, record.Name)
+		return r.v.db.detach(n.ref)
+	case journalUnsetChild:
+		ref, err := r.ref(record.Id)
+		if err != nil {
+			return err
+		}
+		child := ref.child
+		if child == nil {
+			return nil
+		}
+		ref.child = nil
+		return r.v.db.detach(child.ref)
+	case journalMove:
+		from, err := r.ref(record.Parent)
+		if err != nil {
+			return err
+		}
+		to, err := r.ref(record.To)
+		if err != nil {
+			return err
+		}
+		n, ok := from.children[record.Name]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrNotFound, record.Name)
+		}
+		replaced, ok := to.children[record.NewName]
+		delete(from.children, record.Name)
+		n.name = record.NewName
+		to.children[record.NewName] = n
+		if ok && replaced != n {
+			return r.v.db.detach(replaced.ref)
+		}
+		return nil
+	case journalAttr:
+		n, err := r.location(record)
+		if err != nil {
+			return err
+		}
+		name := n.name
+		setFsFromJson(n, record.Node.jsonLoc)
+		n.name = name
+		return nil
+	case journalLink:
+		n, err := r.node(record.Id)
+		if err != nil {
+			return err
+		}
+		// the tree is the same as when it was recorded so the path finds the same node
+		ln, _, err := n.fsFrom(record.To, -1)
+		if err != nil {
+			return err
+		}
+		ln.link = record.Link
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q", record.Op)
+	}
+}
+
+// ----------------Replay--------------------
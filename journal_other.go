@@ -0,0 +1,10 @@
+//go:build !unix
+
+package virtualfs
+
+import "os"
+
+// lockJournal isnt supported so the journal isnt locked
+func lockJournal(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package virtualfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// treeLines returns the tree as it would be saved
+func treeLines(t *testing.T, v *Fs) string {
+	t.Helper()
+	lines := []string{}
+	err := v.walkRecursive("/", false, func(path string, child bool, n *Fs) error {
+		line, err := json.Marshal(toJsonFs(path, child, n))
+		lines = append(lines, string(line))
+		return err
+	})
+	fatalfIfErr(t, err, "failed to walk tree")
+	return strings.Join(lines, "\n")
+}
+
+// crash drops v without closing it, releasing the journal lock like the process dying would
+func crash(t *testing.T, v *Fs) {
+	t.Helper()
+	fatalfIfErr(t, v.db.journal.close(), "failed to release journal")
+}
+
+func TestJournal(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		v.TagS("foo", "bar")
+
+		err = createFile(v, "/foo/bar", 0655, time1, helloWorldCompressed)
+		fatalfIfErr(t, err, "failed to create /foo/bar")
+		bar, err := v.Stat("/foo/bar")
+		fatalfIfErr(t, err, "failed to stat /foo/bar")
+		err = createChildFile(bar, 0611, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create child of /foo/bar")
+		bar, err = v.Stat("/foo/bar")
+		fatalfIfErr(t, err, "failed to stat /foo/bar again")
+		bar.TagS("processed", "yes")
+		bar.Warning(fmt.Errorf("yikes"))
+
+		err = createFile(v, "/duplicate", 0644, time2, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /duplicate")
+		err = createFile(v, "/other", 0644, time2, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /other")
+		other, err := v.Stat("/other")
+		fatalfIfErr(t, err, "failed to stat /other")
+		other.Error(fmt.Errorf("bad file"))
+		_, err = v.Symlink("/foo/bar", "/link/symlink", 0777, time3)
+		fatalfIfErr(t, err, "failed to create symlink")
+		_, err = v.Hardlink("/other", "/link/hardlink", 0644, time3)
+		fatalfIfErr(t, err, "failed to create hardlink")
+		err = createFile(v, "/removed", 0644, time2, "Hello, Removed!")
+		fatalfIfErr(t, err, "failed to create /removed")
+
+		fatalfIfErr(t, v.Chown("/other", 1000, 1000), "failed to chown")
+		fatalfIfErr(t, v.Setxattr("/other", XattrSELinux, []byte("system_u")), "failed to set xattr")
+		fatalfIfErr(t, v.Rename("/duplicate", "/moved/duplicate"), "failed to rename")
+		fatalfIfErr(t, v.Remove("/removed"), "failed to remove")
+		assertTmpDirFileCount(t, 5, tmp, "files, fin.db, fin.db.bak and fin.journal")
+
+		//------------ Crash (no Close) and replay
+		expected := treeLines(t, v)
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertEqual(t, expected, treeLines(t, v), "replayed tree should match")
+		assertErr(t, ErrInFilesystem, v.FsError(), "error should be replayed")
+		assertContent(t, "Hello, World!", v, "/moved/duplicate")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		_, err = os.Stat(filepath.Join(tmp, finJournal))
+		assert(t, os.IsNotExist(err), "journal should be removed on close")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after close")
+		assertEqual(t, expected, treeLines(t, v), "saved tree should match")
+	})
+}
+
+func TestJournalCompaction(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(3))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		for i := range 10 {
+			err = createFile(v, fmt.Sprintf("/dir%v/file", i), 0644, time1, fmt.Sprintf("Hello, %v!", i))
+			fatalfIfErr(t, err, "failed to create file %v", i)
+			if v.JournalDue() {
+				fatalfIfErr(t, v.Checkpoint(), "failed to compact after file %v", i)
+			}
+		}
+		assert(t, !v.JournalDue(), "journal shouldnt be due after checkpoint")
+		expected := treeLines(t, v)
+
+		journal, err := os.ReadFile(filepath.Join(tmp, finJournal))
+		fatalfIfErr(t, err, "failed to read journal")
+		lines := 0
+		for _, b := range journal {
+			if b == '\n' {
+				lines++
+			}
+		}
+		assert(t, lines <= 5, "journal should be compacted, has %v lines", lines)
+
+		//------------ Crash with a record half written
+		file, err := os.OpenFile(filepath.Join(tmp, finJournal), os.O_WRONLY|os.O_APPEND, 0644)
+		fatalfIfErr(t, err, "failed to open journal")
+		_, err = file.WriteString(`{"op":"set","par`)
+		fatalfIfErr(t, err, "failed to write journal")
+		file.Close()
+
+		crash(t, v)
+		v, err = NewFsFromDb(tmp, WithJournal(3))
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertErr(t, ErrInFilesystem, v.FsWarning(), "corrupt record should be a warning")
+		v.ref.warns = nil
+		assertEqual(t, expected, treeLines(t, v), "replayed tree should match")
+
+		//------------ Compacting moves the journal into the snapshot
+		err = createFile(v, "/after", 0644, time1, "Hello, After!")
+		fatalfIfErr(t, err, "failed to create /after")
+		fatalfIfErr(t, v.Checkpoint(), "failed to compact")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after compaction")
+		assertContent(t, "Hello, After!", v, "/after")
+	})
+}
+
+func TestJournalWriteError(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		// the journal cant be written or compacted anymore
+		fatalfIfErr(t, v.db.journal.file.Close(), "failed to close journal file")
+		_, err = v.MkdirP("/foo", 0755, time1)
+		assert(t, err != nil, "should return that the change isnt durable")
+	})
+}
+
+func TestJournalUnsupported(t *testing.T) {
+	_, err := newFooMemFs(WithJournal(0))
+	assertErr(t, ErrJournalUnsupported, err, "in memory fs cant journal")
+	tmpDir(t, func(tmp string) {
+		_, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0), WithEncryption(testKey))
+		assertErr(t, ErrJournalEncrypted, err, "encrypted fs cant journal")
+	})
+}
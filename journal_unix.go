@@ -0,0 +1,18 @@
+//go:build unix
+
+package virtualfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockJournal locks the journal so only one process writes it (released when its closed)
+func lockJournal(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrJournalLocked
+	}
+	return err
+}
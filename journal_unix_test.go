@@ -0,0 +1,34 @@
+//go:build unix
+
+package virtualfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalLocked(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/foo/bar", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /foo/bar")
+
+		_, err = NewFsFromDb(tmp, WithJournal(0))
+		assertErr(t, ErrJournalLocked, err, "should only journal in one process")
+		reader, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load without journal")
+		assertContent(t, "Hello, World!", reader, "/foo/bar")
+		assertErr(t, ErrJournalLocked, reader.Close(), "shouldnt save over the process journaling")
+		_, err = os.Stat(filepath.Join(tmp, finJournal))
+		fatalfIfErr(t, err, "journal should only be removed by the process journaling")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		_, err = os.Stat(filepath.Join(tmp, finJournal))
+		assert(t, os.IsNotExist(err), "journal should be removed on close")
+		v, err = NewFsFromDb(tmp, WithJournal(0))
+		fatalfIfErr(t, err, "failed to journal after close")
+		fatalfIfErr(t, v.Close(), "failed to close again")
+	})
+}
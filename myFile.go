@@ -3,7 +3,6 @@ package virtualfs
 import (
 	"fmt"
 
-	"github.com/jonathongardner/fifo/buffer"
 	"github.com/jonathongardner/fifo/identifiers"
 )
 
@@ -22,20 +21,27 @@ type myFile struct {
 	identifiers *identifiers.Writer
 	file        destination
 	node        *Fs
+	name        string
 }
 
-// createCachedMyWriterCloser creates a new myFile writer with cached file
-func createMyWriterCloser(node *Fs, path string) (*myFile, error) {
-	file, err := buffer.NewFileWriter(path, bufferSize)
+// createMyWriterCloser creates a new myFile writer that writes to the store
+func createMyWriterCloser(node *Fs) (*myFile, error) {
+	file, err := node.db.store.create(node.ref.id)
 	if err != nil {
 		return nil, err
 	}
-	toReturn := &myFile{
+	toReturn := newMyFile(node, file)
+	toReturn.name = node.ref.id
+	return toReturn, nil
+}
+
+// newMyFile creates a new myFile writer that writes to file
+func newMyFile(node *Fs, file destination) *myFile {
+	return &myFile{
 		identifiers: identifiers.NewWriter(file),
 		file:        file,
 		node:        node,
 	}
-	return toReturn, nil
 }
 
 func (mwc *myFile) Write(p []byte) (int, error) {
@@ -54,6 +60,7 @@ func (mwc *myFile) Close() error {
 	}
 
 	ref := mwc.node.ref
+	id := ref.id
 	ref.size = identifiers.Size
 	ref.md5 = identifiers.Md5
 	ref.sha1 = identifiers.Sha1
@@ -68,12 +75,33 @@ func (mwc *myFile) Close() error {
 		return fmt.Errorf("error updating reference %w", err)
 	}
 
-	if updated {
+	// id changes if another root in the catalog already stored the file
+	if updated || mwc.node.ref.id != mwc.name {
 		if err := mwc.file.Delete(); err != nil {
 			return fmt.Errorf("error deleting file %w", err)
 		}
 	}
-	return mwc.file.Close()
+	if err := mwc.file.Close(); err != nil {
+		return err
+	}
+	if mwc.node.db.journal == nil {
+		return nil
+	}
+	data := toJsonFs("", false, mwc.node)
+	return mwc.node.db.record(journalRecord{Op: journalRef, Id: id, Node: &data})
 }
 
 //------------- myFile ------------------
+
+// discard is a destination that doesnt keep anything (i.e. for sections)
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+func (discard) Close() error {
+	return nil
+}
+func (discard) Delete() error {
+	return nil
+}
@@ -1,8 +1,8 @@
 package virtualfs
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sync"
 
@@ -24,14 +24,24 @@ type reference struct {
 	sha256   string
 	sha512   string
 	entropy  float64
-	err      error
-	warn     []error
+	errs     []*Diagnostic
+	warns    []*Diagnostic
 	tags     sync.Map
 	child    *Fs
 	children map[string]*Fs
+	// if set, the contents are a section of source instead of stored
+	source *reference
+	offset int64
+	// entries, layers and sections using it (see referenceDB.attach)
+	links int
+	// counted as using its stored file (see referenceDB.blobs)
+	stored bool
 }
 
 func (r *reference) storagePath(storageDir string) string {
+	if storageDir == "" || r.source != nil {
+		return ""
+	}
 	return filepath.Join(storageDir, r.id)
 }
 
@@ -42,8 +52,15 @@ func (r *reference) storagePath(storageDir string) string {
 //	func (r *reference) create(storageDir string) (*os.File, error) {
 //		return os.Create(r.storagePath(storageDir))
 //	}
-func (r *reference) open(storageDir string) (*os.File, error) {
-	return os.Open(r.storagePath(storageDir))
+func (r *reference) open(store blobStore) (File, error) {
+	if r.source != nil {
+		file, err := r.source.open(store)
+		if err != nil {
+			return nil, err
+		}
+		return newSectionFile(file, r.offset, r.size)
+	}
+	return store.open(r.id)
 }
 
 // Return old value, if old valud is true then it was already extracted
@@ -58,13 +75,32 @@ func (r *reference) setChildren(child *Fs) (*Fs, error) {
 	if r.child != nil {
 		return nil, ErrAlreadyHasChild
 	}
+	replaced := r.children[child.name]
 	r.children[child.name] = child
-	return child, nil
+	err := child.db.replace(replaced, child)
+	return child, errors.Join(err, child.db.journalSet(r, child, false))
 }
 func (r *reference) setChild(child *Fs) (*Fs, error) {
 	if len(r.children) != 0 {
 		return nil, ErrAlreadyHasChildren
 	}
+	replaced := r.child
 	r.child = child
-	return child, nil
+	err := child.db.replace(replaced, child)
+	return child, errors.Join(err, child.db.journalSet(r, child, true))
+}
+
+// uses returns the references used by r (its children, layer and source)
+func (r *reference) uses() []*reference {
+	uses := make([]*reference, 0, len(r.children)+2)
+	for _, child := range r.children {
+		uses = append(uses, child.ref)
+	}
+	if r.child != nil {
+		uses = append(uses, r.child.ref)
+	}
+	if r.source != nil {
+		uses = append(uses, r.source)
+	}
+	return uses
 }
@@ -1,20 +1,81 @@
 package virtualfs
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"sync"
 )
 
 type referenceDB struct {
+	// where the files are stored (storageDir is blank if in memory)
 	storageDir string
-	mu         sync.Mutex
-	err        bool
-	warn       bool
-	refMap     map[string]*reference
+	store      blobStore
+	// where fin.db is stored (dbDir is blank if in memory), same as the files
+	// unless the files are shared with other roots in a catalog
+	dbDir   string
+	dbStore blobStore
+	catalog *Catalog
+	root    string
+	// changes since the snapshot (fin.db) with checksum was saved/loaded (see WithJournal)
+	journal      *journal
+	journalEvery int
+	checksum     string
+	header       DBHeader
+	storage      DBStorage
+	mu           sync.Mutex
+	err          bool
+	warn         bool
+	refMap       map[string]*reference
+	// references using each stored file (by name), separate references can have the same contents
+	// (or be stored once in a catalog) so its removed once the last of them is detached
+	blobs map[string]int
 }
 
-func newReferenceDB(storageDir string) *referenceDB {
-	return &referenceDB{storageDir: storageDir, err: false, warn: false, refMap: make(map[string]*reference)}
+func newReferenceDB(storageDir string, opts *options) (*referenceDB, error) {
+	if err := checkMetadataOptions(opts); err != nil {
+		return nil, err
+	}
+	store, err := newStore(newDirStore(storageDir), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &referenceDB{storageDir: storageDir, store: store, dbDir: storageDir, dbStore: store, journalEvery: opts.journal, storage: newDBStorage(opts), err: false, warn: false, refMap: make(map[string]*reference), blobs: make(map[string]int)}, nil
+}
+
+// newMemReferenceDB creates a reference db that keeps everything in memory (see NewMemFs)
+func newMemReferenceDB(opts *options) (*referenceDB, error) {
+	if opts.journal > 0 {
+		return nil, fmt.Errorf("%w: in memory", ErrJournalUnsupported)
+	}
+	if opts.sqlite {
+		return nil, fmt.Errorf("%w: in memory", ErrSQLiteUnsupported)
+	}
+	store, err := newStore(newMemStore(opts.memoryLimit, opts.spillDir), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &referenceDB{store: store, dbStore: store, storage: newDBStorage(opts), err: false, warn: false, refMap: make(map[string]*reference), blobs: make(map[string]int)}, nil
+}
+
+// checkMetadataOptions returns an error if the journal or sqlite (which arent encrypted) are used with encryption
+// or sqlite is used with a compact fin.db
+func checkMetadataOptions(opts *options) error {
+	if opts.sqlite && opts.compact {
+		return ErrCompactSQLite
+	}
+	if opts.keyProvider == nil {
+		return nil
+	}
+	if opts.journal > 0 {
+		return ErrJournalEncrypted
+	}
+	if opts.sqlite {
+		return ErrSQLiteEncrypted
+	}
+	return nil
 }
 
 func (rdb *referenceDB) updateIfDuplicate(passedRef *reference) (*reference, bool) {
@@ -31,9 +92,149 @@ func (rdb *referenceDB) updateIfDuplicate(passedRef *reference) (*reference, boo
 	}
 
 	rdb.refMap[passedRef.sha512] = passedRef
+	rdb.storeLocked(passedRef)
 	return passedRef, false
 }
 
+// register adds a loaded reference so files added later with the same sha512 are deduplicated,
+// unlike updateIfDuplicate references saved separately are kept separate
+func (rdb *referenceDB) register(ref *reference) {
+	if ref.sha512 == "" {
+		return
+	}
+
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	if _, ok := rdb.refMap[ref.sha512]; !ok {
+		rdb.refMap[ref.sha512] = ref
+	}
+	rdb.storeLocked(ref)
+}
+
+// storeLocked counts ref as using its stored file (see blobs)
+func (rdb *referenceDB) storeLocked(ref *reference) {
+	// sections arent stored so nothing to share
+	if ref.source != nil {
+		return
+	}
+	if rdb.catalog != nil {
+		ref.id = rdb.catalog.claim(rdb.root, ref.sha512, ref.id)
+	}
+	ref.stored = true
+	rdb.blobs[ref.id]++
+}
+
+const finDB = "fin.db"
+
+// useSQLite returns true if fin.sqlite should be loaded, the format of the options is used if both
+// exist (i.e. the process died after saving one before removing the other)
+func (rdb *referenceDB) useSQLite() bool {
+	if rdb.dbDir == "" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(rdb.dbDir, finSQLite)); err != nil {
+		return false
+	}
+	if rdb.storage.SQLite {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(rdb.dbDir, finDB))
+	return err != nil
+}
+
+// finDBPath returns the path to the db, blank if the db is in memory
 func (rdb *referenceDB) finDBPath() string {
-	return filepath.Join(rdb.storageDir, "fin.db")
+	if rdb.dbDir == "" {
+		return ""
+	}
+	return filepath.Join(rdb.dbDir, finDB)
+}
+
+// storedNames returns the names of the files stored for the references loaded (see trackChunks),
+// nothing if in a catalog since the catalog tracks the files it stores
+func (rdb *referenceDB) storedNames() []string {
+	if rdb.catalog != nil {
+		return nil
+	}
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	names := make([]string, 0, len(rdb.blobs))
+	for name := range rdb.blobs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ------------- links ------------------
+// replace attaches the entry or layer added and detaches the one it replaced (if any)
+func (rdb *referenceDB) replace(replaced, added *Fs) error {
+	rdb.attach(added.ref)
+	if replaced == nil {
+		return nil
+	}
+	return rdb.detach(replaced.ref)
 }
+
+// attach counts a use of ref (an entry, layer or section of it) and everything it uses if its the first
+func (rdb *referenceDB) attach(ref *reference) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	toAttach := []*reference{ref}
+	for len(toAttach) > 0 {
+		ref := toAttach[len(toAttach)-1]
+		toAttach = toAttach[:len(toAttach)-1]
+		ref.links++
+		if ref.links > 1 {
+			continue
+		}
+		toAttach = append(toAttach, ref.uses()...)
+	}
+}
+
+// detach removes a use of ref (see attach), if it was the last one everything it uses is detached
+// and its stored file is removed
+func (rdb *referenceDB) detach(ref *reference) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	toDetach := []*reference{ref}
+	for len(toDetach) > 0 {
+		ref := toDetach[len(toDetach)-1]
+		toDetach = toDetach[:len(toDetach)-1]
+		ref.links--
+		// not counted (see MetaDB) if less than 0
+		if ref.links != 0 {
+			continue
+		}
+		toDetach = append(toDetach, ref.uses()...)
+
+		if rdb.refMap[ref.sha512] == ref {
+			delete(rdb.refMap, ref.sha512)
+		}
+		// references dropped for a duplicate and sections arent stored (see storeLocked)
+		if !ref.stored {
+			continue
+		}
+		ref.stored = false
+		rdb.blobs[ref.id]--
+		if rdb.blobs[ref.id] > 0 {
+			continue
+		}
+		delete(rdb.blobs, ref.id)
+		var err error
+		if rdb.catalog != nil {
+			err = rdb.catalog.release(rdb.root, ref.sha512)
+		} else {
+			err = rdb.store.remove(ref.id)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error removing %v - %w", ref.id, err)
+		}
+	}
+	return nil
+}
+
+// ------------- links ------------------
@@ -2,10 +2,20 @@ package virtualfs
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/jonathongardner/fifo/filetype"
@@ -19,57 +29,554 @@ func (v *Fs) DBDir() string {
 	return v.db.storageDir
 }
 
+// save writes the tree to fin.db (or fin.sqlite, see WithSQLite) and empties the journal since its now in the snapshot
 func (v *Fs) save() error {
-	dbFile := v.FinDBPath()
-	file, err := os.Create(dbFile)
+	// the snapshot of another process journaling (see WithJournal) cant be changed
+	if err := v.db.checkJournal(); err != nil {
+		return err
+	}
+	var checksum string
+	var err error
+	if v.db.storage.SQLite {
+		checksum, err = v.writeSQLite()
+		if err == nil {
+			err = v.db.dbStore.remove(finDB)
+		}
+	} else {
+		checksum, err = v.writeSnapshot()
+		if err == nil && v.db.dbDir != "" {
+			err = os.Remove(filepath.Join(v.db.dbDir, finSQLite))
+		}
+	}
+	// the other format is removed so its not loaded instead
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	v.db.checksum = checksum
+	if v.db.journal != nil {
+		return v.db.journal.reset(checksum)
+	}
+	return nil
+}
+
+// writeSnapshot writes the tree to fin.db (compact if WithCompactDB) returning its checksum
+func (v *Fs) writeSnapshot() (string, error) {
+	if v.db.storage.Compact {
+		return v.writeCompact()
+	}
+	file, err := v.db.dbStore.create(finDB)
 	if err != nil {
-		return fmt.Errorf("error opneing file %v - %w", dbFile, err)
+		return "", fmt.Errorf("error opneing file %v - %w", finDB, err)
 	}
-	defer file.Close()
 
-	err = v.walkRecursive("/", false, func(path string, child bool, fs *Fs) error {
-		jsonString, err := json.Marshal(toJsonFs(path, child, fs))
-		if err != nil {
-			return fmt.Errorf("error marshalling file info %v - %w", path, err)
-		}
-		// encoder := json.NewEncoder(file)
-		// encoder.Encode(toSave)
-		_, err = file.Write(jsonString)
+	h := sha256.New()
+	w := io.MultiWriter(file, h)
+	if err := v.writeHeader(w); err != nil {
+		file.Delete()
+		return "", err
+	}
+	d := newDBWriter(func(entry dbEntry) error {
+		line, err := json.Marshal(entry)
 		if err != nil {
-			return err
+			return fmt.Errorf("error marshalling entry - %w", err)
 		}
-		_, err = file.WriteString("\n")
+		_, err = w.Write(append(line, '\n'))
 		return err
 	})
+	if err := d.writeLoc(v, "", false); err != nil {
+		file.Delete()
+		return "", err
+	}
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+	trailer, err := json.Marshal(dbTrailer{Checksum: checksum, Count: d.count + 1})
 	if err != nil {
+		file.Delete()
+		return "", fmt.Errorf("error marshalling trailer - %w", err)
+	}
+	if _, err := file.Write(append(trailer, '\n')); err != nil {
+		file.Delete()
+		return "", err
+	}
+	return checksum, file.Close()
+}
+
+// dbWriter writes the entries (see dbEntry) of a tree writing each reference once
+type dbWriter struct {
+	write func(dbEntry) error
+	refs  map[string]bool
+	// references whose layers or children are written, a reference might be written as the
+	// source of a section before its location is (see writeRef)
+	walked map[string]bool
+	count  int
+}
+
+func newDBWriter(write func(dbEntry) error) *dbWriter {
+	return &dbWriter{write: write, refs: make(map[string]bool), walked: make(map[string]bool)}
+}
+
+func (d *dbWriter) entry(entry dbEntry) error {
+	d.count++
+	return d.write(entry)
+}
+
+// writeRef writes ref (after the source of a section) the first time its seen
+func (d *dbWriter) writeRef(ref *reference) error {
+	if d.refs[ref.id] {
+		return nil
+	}
+	d.refs[ref.id] = true
+	if ref.source != nil {
+		if err := d.writeRef(ref.source); err != nil {
+			return err
+		}
+	}
+	data := toJsonRef(ref)
+	return d.entry(dbEntry{Ref: &data})
+}
+
+// writeLoc writes n in parent (the id of a reference, blank for the root) and the first time its
+// reference is at a location its layers or children (see writeRef)
+func (d *dbWriter) writeLoc(n *Fs, parent string, child bool) error {
+	if err := d.writeRef(n.ref); err != nil {
+		return err
+	}
+	loc := toJsonLoc(child, n)
+	if err := d.entry(dbEntry{Parent: parent, Uid: n.ref.id, Loc: &loc}); err != nil {
 		return err
 	}
+	if d.walked[n.ref.id] {
+		return nil
+	}
+	d.walked[n.ref.id] = true
+
+	if n.ref.child != nil {
+		return d.writeLoc(n.ref.child, n.ref.id, true)
+	}
+	names := make([]string, 0, len(n.ref.children))
+	for name := range n.ref.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := d.writeLoc(n.ref.children[name], n.ref.id, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------Header--------------------------------
+// dbFormat is the format of fin.db written, older formats are migrated when loaded (see dbMigrations)
+var dbFormat = len(dbMigrations) + 1
+
+// dbMigration upgrades an entry (a line) of fin.db to the next format
+type dbMigration func(line []byte) ([]byte, error)
+
+// dbMigrations[i] upgrades the entries of format i+1 to i+2, nil if the entries didnt change
+var dbMigrations = []dbMigration{
+	// 2 added the header and trailer and saves references separately from their locations (see dbEntry)
+	// with typed tag values and diagnostics. Format 1 is loaded by path (see loadPaths) since a line
+	// cant be migrated on its own
+	migrateBaseline,
+}
+
+// migrateBaseline upgrades a jsonFs of format 1 (see typeTags and messageDiagnostics)
+func migrateBaseline(line []byte) ([]byte, error) {
+	line, err := typeTags(line)
+	if err != nil {
+		return nil, err
+	}
+	return messageDiagnostics(line)
+}
+
+const modulePath = "github.com/jonathongardner/virtualfs"
+
+// DBHeader is the first line of fin.db describing how it was written (see Fs.DBHeader)
+// dbs without a header (format 1) were saved before it was added
+type DBHeader struct {
+	Format int `json:"format"`
+	// version of this library that saved the db
+	Library string    `json:"library"`
+	Created time.Time `json:"created"`
+	Hashes  []string  `json:"hashes"`
+	Storage DBStorage `json:"storage"`
+}
+
+// dbHashes are the hashes saved for each reference
+var dbHashes = []string{"md5", "sha1", "sha256", "sha512"}
+
+// DBStorage is the storage layout (the options) used
+type DBStorage struct {
+	Compression bool `json:"compression,omitempty"`
+	Encryption  bool `json:"encryption,omitempty"`
+	Defang      bool `json:"defang,omitempty"`
+	Chunking    bool `json:"chunking,omitempty"`
+	Catalog     bool `json:"catalog,omitempty"`
+	Journal     bool `json:"journal,omitempty"`
+	SQLite      bool `json:"sqlite,omitempty"`
+	Compact     bool `json:"compact,omitempty"`
+}
+
+func newDBStorage(o *options) DBStorage {
+	return DBStorage{
+		Compression: o.compress,
+		Encryption:  o.keyProvider != nil,
+		Defang:      len(o.defangKey) > 0,
+		Chunking:    o.chunk,
+		Journal:     o.journal > 0,
+		SQLite:      o.sqlite,
+		Compact:     o.compact,
+	}
+}
 
+// checkStorage returns ErrStorageMismatch if the stored files were written with a different storage
+// layout than the options, theyd be read as the bytes of the layers that arent used otherwise
+func (rdb *referenceDB) checkStorage(header DBHeader) error {
+	// format 1 didnt have a header
+	if header.Format < 2 {
+		return nil
+	}
+	saved, opts := header.Storage, rdb.storage
+	mismatched := []string{}
+	for _, layer := range []struct {
+		name        string
+		saved, opts bool
+	}{
+		{"compression", saved.Compression, opts.Compression},
+		{"encryption", saved.Encryption, opts.Encryption},
+		{"defang", saved.Defang, opts.Defang},
+		{"chunking", saved.Chunking, opts.Chunking},
+	} {
+		if layer.saved != layer.opts {
+			mismatched = append(mismatched, fmt.Sprintf("%v (saved %v)", layer.name, layer.saved))
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%w: %v", ErrStorageMismatch, strings.Join(mismatched, ", "))
+	}
+	if !slices.Equal(header.Hashes, dbHashes) {
+		return fmt.Errorf("%w: hashes %v instead of %v", ErrUnsupportedFormat, header.Hashes, dbHashes)
+	}
 	return nil
 }
 
-func (v *Fs) load(storageDir string) error {
-	v.db = newReferenceDB(storageDir)
+// DBHeader returns the header of the db loaded (or last saved), Format is 0 if it hasnt been saved
+func (v *Fs) DBHeader() DBHeader {
+	return v.db.header
+}
 
-	dbFile := v.db.finDBPath()
-	file, err := os.Open(dbFile)
+// newHeader returns the header to save keeping when the db was created
+func (rdb *referenceDB) newHeader() DBHeader {
+	created := rdb.header.Created
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	rdb.header = DBHeader{
+		Format:  dbFormat,
+		Library: libraryVersion(),
+		Created: created,
+		Hashes:  dbHashes,
+		Storage: rdb.storage,
+	}
+	return rdb.header
+}
+
+// writeHeader writes the header (see newHeader)
+func (v *Fs) writeHeader(w io.Writer) error {
+	header, err := json.Marshal(v.db.newHeader())
+	if err != nil {
+		return fmt.Errorf("error marshalling header - %w", err)
+	}
+	_, err = w.Write(append(header, '\n'))
+	return err
+}
+
+// parseHeader returns the header if line is one, format 1 (the entries start on the first line) if not
+func parseHeader(line []byte) (DBHeader, bool, error) {
+	header := DBHeader{}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, false, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	if header.Format == 0 {
+		return DBHeader{Format: 1}, false, nil
+	}
+	if header.Format > dbFormat {
+		return header, true, fmt.Errorf("%w: format %v (written by %v) is newer than %v, upgrade to load it", ErrUnsupportedFormat, header.Format, header.Library, dbFormat)
+	}
+	return header, true, nil
+}
+
+// migrate upgrades an entry from format from to format to
+func migrate(line []byte, from, to int) ([]byte, error) {
+	for _, migration := range dbMigrations[from-1 : to-1] {
+		if migration == nil {
+			continue
+		}
+		var err error
+		if line, err = migration(line); err != nil {
+			return nil, err
+		}
+	}
+	return line, nil
+}
+
+// libraryVersion returns the version of this module in the binary, (devel) if unknown (i.e. tests)
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// ------------------------------Header--------------------------------
+
+// dbTrailer is the last line of fin.db so load can detect a truncated or corrupt db
+type dbTrailer struct {
+	Checksum string `json:"checksum"`
+	Count    int    `json:"count"`
+}
+
+// dbLines reads the lines of a db hashing them as theyre read so its checked against the trailer
+// in the same pass its loaded
+type dbLines struct {
+	sc    *bufio.Scanner
+	h     hash.Hash
+	line  []byte
+	ahead bool
+	count int
+	// nil if the db doesnt have a trailer (saved before they were added)
+	trailer *dbTrailer
+}
+
+func newDBLines(r io.Reader) *dbLines {
+	l := &dbLines{sc: newDBScanner(r), h: sha256.New()}
+	l.ahead = l.sc.Scan()
+	return l
+}
+
+// scan advances to the next line, false at the trailer (or the end if there isnt one)
+func (l *dbLines) scan() bool {
+	if !l.ahead {
+		return false
+	}
+	l.line = append(l.line[:0], l.sc.Bytes()...)
+	l.ahead = l.sc.Scan()
+	if !l.ahead {
+		// entries dont have a checksum so the last line is only the trailer if it does
+		trailer := dbTrailer{}
+		if json.Unmarshal(l.line, &trailer) == nil && trailer.Checksum != "" {
+			l.trailer = &trailer
+			return false
+		}
+	}
+	l.h.Write(l.line)
+	l.h.Write([]byte{'\n'})
+	l.count++
+	return true
+}
+
+// bytes returns the current line
+func (l *dbLines) bytes() []byte {
+	return l.line
+}
+
+// verify checks the lines read match the trailer once scan is done, the trailer is optional for dbs
+// without a header
+func (l *dbLines) verify(hasHeader bool) error {
+	if err := l.sc.Err(); err != nil {
+		return fmt.Errorf("error reading db - %w", err)
+	}
+	if l.trailer == nil {
+		if hasHeader {
+			return fmt.Errorf("%w: missing trailer", ErrCorruptDB)
+		}
+		return nil
+	}
+	if l.count == 0 {
+		return fmt.Errorf("%w: no entries", ErrCorruptDB)
+	}
+	if l.trailer.Count != l.count || l.trailer.Checksum != hex.EncodeToString(l.h.Sum(nil)) {
+		return fmt.Errorf("%w: checksum doesnt match", ErrCorruptDB)
+	}
+	return nil
+}
+
+// checksum returns the checksum of the trailer, blank if there isnt one
+func (l *dbLines) checksum() string {
+	if l.trailer == nil {
+		return ""
+	}
+	return l.trailer.Checksum
+}
+
+// newDBScanner returns a scanner for the lines of a db
+func newDBScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	// files with a lot of tags or xattrs can have long lines
+	sc.Buffer(nil, 64*1024*1024)
+	return sc
+}
+
+// load loads fin.db falling back to the previous generation (see atomicFile) if its corrupt
+// or fin.sqlite if thats the format used (see WithSQLite)
+func (v *Fs) load() error {
+	if v.db.useSQLite() {
+		return v.loadSQLite()
+	}
+	err := v.loadFrom(finDB)
+	if err == nil {
+		return nil
+	}
+	// the previous db is most likely newer (or stored the same way) too so dont fallback to it
+	if errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrStorageMismatch) {
+		return err
+	}
+	if backupErr := v.loadFrom(finDB + backupSuffix); backupErr != nil {
+		return err
+	}
+	v.Warning(fmt.Errorf("loaded previous db - %w", err))
+	return nil
+}
+
+// loadFrom loads the db name checking it against its trailer (see dbLines) as its read, it
+// isnt used until its checked (see refLoader.link) so a corrupt one can be replaced by another
+func (v *Fs) loadFrom(name string) error {
+	file, err := v.db.dbStore.open(name)
 	if err != nil {
 		return fmt.Errorf("error opening db file - %w", err)
 	}
 	defer file.Close()
 
-	sc := bufio.NewScanner(file)
+	r := bufio.NewReader(file)
+	if magic, _ := r.Peek(len(compactMagic)); bytes.Equal(magic, compactMagic) {
+		return v.loadCompact(r)
+	}
+	lines := newDBLines(r)
+	if !lines.scan() {
+		if err := lines.verify(false); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: empty", ErrCorruptDB)
+	}
+	header, hasHeader, err := parseHeader(lines.bytes())
+	if err != nil {
+		return err
+	}
+	if err := v.db.checkStorage(header); err != nil {
+		return err
+	}
+	// in case a previous load failed part way
+	v.db.refMap = make(map[string]*reference)
+	v.db.blobs = make(map[string]int)
+	v.db.err, v.db.warn = false, false
+	v.db.header = header
 
-	sc.Scan()
-	jsonFs, err := unmarshal(sc.Bytes())
+	if !hasHeader {
+		err = v.loadPaths(lines)
+	} else {
+		err = v.loadEntries(func() (dbEntry, bool, error) {
+			if !lines.scan() {
+				return dbEntry{}, false, lines.verify(true)
+			}
+			line, err := migrate(lines.bytes(), header.Format, dbFormat)
+			if err != nil {
+				return dbEntry{}, false, fmt.Errorf("error migrating from format %v - %w", header.Format, err)
+			}
+			entry := dbEntry{}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return dbEntry{}, false, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+			}
+			return entry, true, nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	v.db.checksum = lines.checksum()
+	return nil
+}
+
+// loadEntries loads the entries (see dbEntry) returned by next until it returns false
+func (v *Fs) loadEntries(next func() (dbEntry, bool, error)) error {
+	refs := newRefLoader(v.db)
+	root := false
+	for {
+		entry, ok, err := next()
+		if err != nil {
+			return fmt.Errorf("error reading db - %w", err)
+		}
+		if !ok {
+			break
+		}
+		if entry.Ref != nil {
+			refs.add(*entry.Ref)
+			continue
+		}
+		if entry.Loc == nil {
+			return fmt.Errorf("%w: entry isnt a reference or location", ErrCorruptDB)
+		}
+
+		ref, err := refs.get(entry.Uid)
+		if err != nil {
+			return err
+		}
+		n := &Fs{db: v.db}
+		if entry.Parent == "" {
+			if root {
+				return fmt.Errorf("%w: more than one root", ErrCorruptDB)
+			}
+			root, n = true, v
+		} else {
+			parent, err := refs.get(entry.Parent)
+			if err != nil {
+				return err
+			}
+			if entry.Loc.Child {
+				parent.child = n
+			} else {
+				parent.children[entry.Loc.Name] = n
+			}
+		}
+		setFsFromJson(n, *entry.Loc)
+		n.ref = ref
+	}
+	if !root {
+		return fmt.Errorf("%w: no root", ErrCorruptDB)
+	}
+	return refs.link()
+}
+
+// loadPaths loads the lines (a jsonFs per location) of format 1 dbs, the root is the current line
+func (v *Fs) loadPaths(lines *dbLines) error {
+	entry := func() (jsonFs, error) {
+		line, err := migrate(lines.bytes(), 1, dbFormat)
+		if err != nil {
+			return jsonFs{}, fmt.Errorf("error migrating from format 1 - %w", err)
+		}
+		return unmarshal(line)
+	}
+
+	jsonFs, err := entry()
 	if err != nil {
 		return fmt.Errorf("error unmarshalling root Fs - %w", err)
 	}
-	fromJsonFs(v, jsonFs)
+	if err := fromJsonFs(v, jsonFs); err != nil {
+		return fmt.Errorf("error fromJsonFs root - %w", err)
+	}
 
-	for sc.Scan() {
+	for lines.scan() {
 		fs := &Fs{db: v.db}
-		jsonFs, err := unmarshal(sc.Bytes())
+		jsonFs, err := entry()
 		if err != nil {
 			return fmt.Errorf("error unmarshinling Fs - %w", err)
 		}
@@ -81,6 +588,9 @@ func (v *Fs) load(storageDir string) error {
 		if err != nil {
 			return fmt.Errorf("error splitting path %v - %w", jsonFs.Path, err)
 		}
+		if len(paths) == 0 {
+			return fmt.Errorf("%w: more than one root", ErrCorruptDB)
+		}
 		lastPath := len(paths) - 1
 		parent, err := v.travelTo(paths[:lastPath], -1)
 		if err != nil {
@@ -100,83 +610,209 @@ func (v *Fs) load(storageDir string) error {
 		}
 	}
 
-	err = sc.Err()
-	if err != nil {
-		return fmt.Errorf("error reading db - %w", err)
+	return lines.verify(false)
+}
+
+// refLoader loads references by the id they were saved with so locations that shared
+// a reference (i.e. hardlinks) share it again
+type refLoader struct {
+	db   *referenceDB
+	refs map[string]*reference
+	// in the order loaded so the same reference is registered first each time
+	loaded  []*reference
+	sources map[*reference]string
+}
+
+func newRefLoader(db *referenceDB) *refLoader {
+	return &refLoader{db: db, refs: make(map[string]*reference), sources: make(map[*reference]string)}
+}
+
+// add creates the reference the first time its id is loaded, returning that one after
+func (l *refLoader) add(data jsonRef) *reference {
+	if ref, ok := l.refs[data.Uid]; ok {
+		return ref
+	}
+	ref := &reference{
+		id:       data.Uid,
+		size:     data.Size,
+		typ:      data.Type,
+		md5:      data.MD5,
+		sha1:     data.SHA1,
+		sha256:   data.SHA256,
+		sha512:   data.SHA512,
+		entropy:  data.Entropy,
+		offset:   data.Offset,
+		children: make(map[string]*Fs),
+	}
+	for k, v := range data.Tags {
+		ref.tags.Store(k, v.value)
+	}
+	ref.errs, ref.warns = data.diagnostics()
+	l.db.err = l.db.err || len(ref.errs) > 0
+	l.db.warn = l.db.warn || len(ref.warns) > 0
+	// sections are resolved in link since the source might not be loaded yet
+	if data.Source != "" {
+		l.sources[ref] = data.Source
 	}
+	l.refs[data.Uid] = ref
+	l.loaded = append(l.loaded, ref)
+	return ref
+}
 
-	err = os.Remove(dbFile)
-	if err != nil {
-		return fmt.Errorf("error removing db - %w", err)
+// get returns the reference loaded with id
+func (l *refLoader) get(id string) (*reference, error) {
+	ref, ok := l.refs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown reference %v", ErrCorruptDB, id)
+	}
+	return ref, nil
+}
+
+// missing returns the ids of sources of sections that havent been loaded
+func (l *refLoader) missing() []string {
+	ids := []string{}
+	for _, source := range l.sources {
+		if _, ok := l.refs[source]; !ok && !slices.Contains(ids, source) {
+			ids = append(ids, source)
+		}
 	}
+	return ids
+}
 
+// link sets the source of sections and registers the references (see referenceDB.register)
+func (l *refLoader) link() error {
+	for ref, source := range l.sources {
+		ref.source = l.refs[source]
+		if ref.source == nil {
+			return fmt.Errorf("error finding source %v for section %v - %w", source, ref.id, ErrNotFound)
+		}
+	}
+	for _, ref := range l.loaded {
+		l.db.register(ref)
+	}
 	return nil
 }
 
+// dbEntry is a line of fin.db (after the header), either a reference the first time its saved or
+// a location of the reference Uid in the children (or child) of the reference Parent (blank for the root)
+type dbEntry struct {
+	Ref    *jsonRef `json:"ref,omitempty"`
+	Parent string   `json:"parent,omitempty"`
+	Uid    string   `json:"uid,omitempty"`
+	Loc    *jsonLoc `json:"loc,omitempty"`
+}
+
+// jsonRef is a reference (everything unique to a file)
+type jsonRef struct {
+	Uid     string              `json:"uid"`
+	Type    filetype.Filetype   `json:"type"`
+	Tags    map[string]tagValue `json:"tags"`
+	Size    int64               `json:"size"`
+	MD5     string              `json:"md5"`
+	SHA1    string              `json:"sha1"`
+	SHA256  string              `json:"sha256"`
+	SHA512  string              `json:"sha512"`
+	Entropy float64             `json:"entropy"`
+	Source  string              `json:"source"`
+	Offset  int64               `json:"offset"`
+	// errors and warnings (see Diagnostic), omitted if none
+	Diagnostics []jsonDiagnostic `json:"diagnostics,omitempty"`
+}
+
+// jsonLoc is the info unique to a location (i.e. not the reference)
+type jsonLoc struct {
+	Child   bool      `json:"child"`
+	Name    string    `json:"name"`
+	Mode    uint32    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	Symlink string    `json:"symlink"`
+	// owner and times (see Chown and SetTimes), omitted if not set
+	UserId     int        `json:"userId,omitempty"`
+	GroupId    int        `json:"groupId,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Group      string     `json:"group,omitempty"`
+	AccessTime *time.Time `json:"accessTime,omitempty"`
+	ChangeTime *time.Time `json:"changeTime,omitempty"`
+	BirthTime  *time.Time `json:"birthTime,omitempty"`
+	// device numbers (see Mknod), omitted if not a device
+	DevMajor int64 `json:"devMajor,omitempty"`
+	DevMinor int64 `json:"devMinor,omitempty"`
+	// extended attributes (see Setxattr), omitted if not set
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+	// shared by hardlinks (see Hardlink), omitted if not hardlinked
+	Link string `json:"link,omitempty"`
+}
+
+// jsonFs is a location with its reference, format 1 dbs are a jsonFs per location
 type jsonFs struct {
-	Path    string            `json:"path"`
-	Child   bool              `json:"child"`
-	Name    string            `json:"name"`
-	Mode    uint32            `json:"mode"`
-	ModTime time.Time         `json:"modTime"`
-	Uid     string            `json:"uid"`
-	Type    filetype.Filetype `json:"type"`
-	Tags    map[string]any    `json:"tags"`
-	Warning []string          `json:"warning"`
-	Error   string            `json:"error"`
-	Symlink string            `json:"symlink"`
-	Size    int64             `json:"size"`
-	MD5     string            `json:"md5"`
-	SHA1    string            `json:"sha1"`
-	SHA256  string            `json:"sha256"`
-	SHA512  string            `json:"sha512"`
-	Entropy float64           `json:"entropy"`
+	Path string `json:"path"`
+	jsonLoc
+	jsonRef
 }
 
 // ------------------------------JSON stuff--------------------------------
 func toJsonFs(path string, child bool, n *Fs) jsonFs {
-	tags := make(map[string]any)
-	n.ref.tags.Range(func(key, value any) bool {
-		tags[key.(string)] = value
+	return jsonFs{Path: path, jsonLoc: toJsonLoc(child, n), jsonRef: toJsonRef(n.ref)}
+}
+
+func toJsonRef(ref *reference) jsonRef {
+	tags := make(map[string]tagValue)
+	ref.tags.Range(func(key, value any) bool {
+		tags[key.(string)] = tagValue{value}
 		return true // Return true to continue iterating
 	})
 
-	err := ""
-	if n.ref.err != nil {
-		err = n.ref.err.Error()
+	diagnostics := []jsonDiagnostic{}
+	for _, d := range append(slices.Clone(ref.errs), ref.warns...) {
+		diagnostics = append(diagnostics, toJsonDiagnostic(d))
+	}
+
+	source := ""
+	if ref.source != nil {
+		source = ref.source.id
 	}
-	warning := []string{}
-	for _, e := range n.ref.warn {
-		warning = append(warning, e.Error())
+	return jsonRef{
+		Tags:        tags,
+		Uid:         ref.id,
+		Type:        ref.typ,
+		Size:        ref.size,
+		MD5:         ref.md5,
+		SHA1:        ref.sha1,
+		SHA256:      ref.sha256,
+		SHA512:      ref.sha512,
+		Entropy:     ref.entropy,
+		Source:      source,
+		Offset:      ref.offset,
+		Diagnostics: diagnostics,
 	}
+}
 
-	ref := n.ref
-	return jsonFs{
-		Path:    path,
+func toJsonLoc(child bool, n *Fs) jsonLoc {
+	return jsonLoc{
 		Child:   child,
 		Name:    n.name,
 		Mode:    uint32(n.mode),
 		ModTime: n.modTime,
 		Symlink: n.symlinkPath,
-		Tags:    tags,
-		Error:   err,
-		Warning: warning,
-		Uid:     ref.id,
-		Type:    ref.typ,
-		Size:    ref.size,
-		MD5:     ref.md5,
-		SHA1:    ref.sha1,
-		SHA256:  ref.sha256,
-		SHA512:  ref.sha512,
-		Entropy: ref.entropy,
+		// owner and times
+		UserId:     n.uid,
+		GroupId:    n.gid,
+		User:       n.uname,
+		Group:      n.gname,
+		AccessTime: timePtr(n.times.Access),
+		ChangeTime: timePtr(n.times.Change),
+		BirthTime:  timePtr(n.times.Birth),
+		DevMajor:   n.devMajor,
+		DevMinor:   n.devMinor,
+		Xattrs:     n.xattrs,
+		Link:       n.link,
 	}
 }
 
+// fromJsonFs sets n from data sharing the reference of files with the same sha512
+// (see refLoader for dbs that save references separately)
 func fromJsonFs(n *Fs, data jsonFs) error {
-	n.name = data.Name
-	n.mode = os.FileMode(data.Mode)
-	n.modTime = data.ModTime
-	n.symlinkPath = data.Symlink
+	setFsFromJson(n, data.jsonLoc)
 
 	var updated bool
 	n.ref, updated = n.db.updateIfDuplicate(&reference{
@@ -188,6 +824,7 @@ func fromJsonFs(n *Fs, data jsonFs) error {
 		sha256:   data.SHA256,
 		sha512:   data.SHA512,
 		entropy:  data.Entropy,
+		offset:   data.Offset,
 		children: make(map[string]*Fs),
 	})
 
@@ -196,19 +833,57 @@ func fromJsonFs(n *Fs, data jsonFs) error {
 	}
 
 	for k, v := range data.Tags {
-		n.ref.tags.Store(k, v)
+		n.ref.tags.Store(k, v.value)
+	}
+
+	n.ref.errs, n.ref.warns = data.diagnostics()
+	n.db.err = n.db.err || len(n.ref.errs) > 0
+	n.db.warn = n.db.warn || len(n.ref.warns) > 0
+	return nil
+}
+
+// diagnostics returns the errors and warnings
+func (data jsonRef) diagnostics() (errs, warns []*Diagnostic) {
+	for _, d := range data.Diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, d.diagnostic())
+		} else {
+			warns = append(warns, d.diagnostic())
+		}
 	}
+	return errs, warns
+}
+
+// setFsFromJson sets the info unique to the location (i.e. not the reference)
+func setFsFromJson(n *Fs, data jsonLoc) {
+	n.name = data.Name
+	n.mode = os.FileMode(data.Mode)
+	n.modTime = data.ModTime
+	n.symlinkPath = data.Symlink
+	n.uid = data.UserId
+	n.gid = data.GroupId
+	n.uname = data.User
+	n.gname = data.Group
+	n.times = Times{Access: timeValue(data.AccessTime), Change: timeValue(data.ChangeTime), Birth: timeValue(data.BirthTime)}
+	n.devMajor = data.DevMajor
+	n.devMinor = data.DevMinor
+	n.xattrs = data.Xattrs
+	n.link = data.Link
+}
 
-	if data.Error != "" {
-		n.ref.err = fmt.Errorf(data.Error)
-		n.db.err = true
+// timePtr returns nil for zero times so they are omitted
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
+	return &t
+}
 
-	n.db.warn = n.db.warn || len(data.Warning) > 0
-	for _, w := range data.Warning {
-		n.ref.warn = append(n.ref.warn, errors.New(w))
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
 	}
-	return nil
+	return *t
 }
 
 func unmarshal(dataBytes []byte) (jsonFs, error) {
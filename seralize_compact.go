@@ -0,0 +1,540 @@
+package virtualfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ErrCompactSQLite fin.db isnt saved when using sqlite so it cant be compact
+var ErrCompactSQLite = fmt.Errorf("compact db cant be used with sqlite")
+
+// compactMagic is the start of a compact fin.db (the cbor self describe tag) so it can be told apart
+// from json (which starts with {)
+var compactMagic = []byte{0xd9, 0xd9, 0xf7}
+
+// A compact fin.db (see WithCompactDB) is compactMagic followed by a cbor sequence of the header (json),
+// the entries (see dbEntry) as compactRef or compactLoc and the trailer (a map). Strings that repeat
+// (names, ids, filetypes, etc) are only written the first time, after that the index they were seen is
+const (
+	compactLocKind uint8 = iota
+	compactRefKind
+)
+
+// compactRef is a jsonRef, Uid, Type, tag keys, Source and the strings of Diagnostics are strings or the
+// index of the string
+type compactRef struct {
+	_           struct{} `cbor:",toarray"`
+	Kind        uint8
+	Uid         any
+	Type        any
+	Tags        []compactTag
+	Size        int64
+	MD5         []byte
+	SHA1        []byte
+	SHA256      []byte
+	SHA512      []byte
+	Entropy     float64
+	Source      any
+	Offset      int64
+	Diagnostics []compactDiagnostic
+}
+
+// compactLoc is a location entry, Parent, Uid, Name, User, Group and Link are strings or the index of the string
+type compactLoc struct {
+	_        struct{} `cbor:",toarray"`
+	Kind     uint8
+	Parent   any
+	Uid      any
+	Child    bool
+	Name     any
+	Mode     uint32
+	ModTime  []byte
+	Symlink  string
+	UserId   int
+	GroupId  int
+	User     any
+	Group    any
+	Access   []byte
+	Change   []byte
+	Birth    []byte
+	DevMajor int64
+	DevMinor int64
+	Xattrs   map[string][]byte
+	Link     any
+}
+
+// compactTag is a tag with its value json encoded so it loads the same as fin.db
+type compactTag struct {
+	_     struct{} `cbor:",toarray"`
+	Key   any
+	Value []byte
+}
+
+// compactDiagnostic is a jsonDiagnostic, Code, Severity and Extractor are strings or the index of the string
+type compactDiagnostic struct {
+	_         struct{} `cbor:",toarray"`
+	Code      any
+	Severity  any
+	Message   string
+	Extractor any
+	Offset    int64
+	Time      []byte
+}
+
+// ------------------------------Save--------------------------------
+// writeCompact writes the tree to fin.db in the compact format returning its checksum
+func (v *Fs) writeCompact() (string, error) {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return "", err
+	}
+	file, err := v.db.dbStore.create(finDB)
+	if err != nil {
+		return "", fmt.Errorf("error opneing file %v - %w", finDB, err)
+	}
+
+	if _, err := file.Write(compactMagic); err != nil {
+		file.Delete()
+		return "", err
+	}
+	h := sha256.New()
+	w := &compactWriter{enc: em.NewEncoder(io.MultiWriter(file, h)), strings: make(map[string]uint64)}
+	header, err := json.Marshal(v.db.newHeader())
+	if err != nil {
+		file.Delete()
+		return "", fmt.Errorf("error marshalling header - %w", err)
+	}
+	if err := w.enc.Encode(header); err != nil {
+		file.Delete()
+		return "", err
+	}
+	d := newDBWriter(w.write)
+	if err := d.writeLoc(v, "", false); err != nil {
+		file.Delete()
+		return "", err
+	}
+
+	checksum := hex.EncodeToString(h.Sum(nil))
+	trailer, err := em.Marshal(dbTrailer{Checksum: checksum, Count: d.count + 1})
+	if err != nil {
+		file.Delete()
+		return "", fmt.Errorf("error marshalling trailer - %w", err)
+	}
+	if _, err := file.Write(trailer); err != nil {
+		file.Delete()
+		return "", err
+	}
+	return checksum, file.Close()
+}
+
+type compactWriter struct {
+	enc     *cbor.Encoder
+	strings map[string]uint64
+}
+
+// write writes entry as a compactRef or compactLoc
+func (w *compactWriter) write(entry dbEntry) error {
+	var item any
+	var err error
+	if entry.Ref != nil {
+		item, err = w.compactRef(*entry.Ref)
+	} else {
+		item, err = w.compactLoc(entry)
+	}
+	if err != nil {
+		return err
+	}
+	return w.enc.Encode(item)
+}
+
+// intern returns s the first time its seen and the index it was seen at after that
+func (w *compactWriter) intern(s string) any {
+	if s == "" {
+		return s
+	}
+	if i, ok := w.strings[s]; ok {
+		return i
+	}
+	w.strings[s] = uint64(len(w.strings))
+	return s
+}
+
+// compactRef converts data, NOTE: strings are interned in the order of compactRef (see compactReader.jsonRef)
+func (w *compactWriter) compactRef(data jsonRef) (compactRef, error) {
+	typ, err := json.Marshal(data.Type)
+	if err != nil {
+		return compactRef{}, err
+	}
+	c := compactRef{
+		Kind:    compactRefKind,
+		Size:    data.Size,
+		Entropy: data.Entropy,
+		Offset:  data.Offset,
+	}
+	c.Uid = w.intern(data.Uid)
+	c.Type = w.intern(string(typ))
+	keys := make([]string, 0, len(data.Tags))
+	for key := range data.Tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value, err := json.Marshal(data.Tags[key])
+		if err != nil {
+			return compactRef{}, fmt.Errorf("error marshalling tag %v - %w", key, err)
+		}
+		c.Tags = append(c.Tags, compactTag{Key: w.intern(key), Value: value})
+	}
+	c.Source = w.intern(data.Source)
+	for _, d := range data.Diagnostics {
+		at, err := d.Time.MarshalBinary()
+		if err != nil {
+			return compactRef{}, err
+		}
+		c.Diagnostics = append(c.Diagnostics, compactDiagnostic{
+			Code:      w.intern(d.Code),
+			Severity:  w.intern(string(d.Severity)),
+			Message:   d.Message,
+			Extractor: w.intern(d.Extractor),
+			Offset:    d.Offset,
+			Time:      at,
+		})
+	}
+
+	for _, hash := range []struct {
+		dst *[]byte
+		src string
+	}{{&c.MD5, data.MD5}, {&c.SHA1, data.SHA1}, {&c.SHA256, data.SHA256}, {&c.SHA512, data.SHA512}} {
+		if *hash.dst, err = hex.DecodeString(hash.src); err != nil {
+			return compactRef{}, fmt.Errorf("error decoding hash %v - %w", hash.src, err)
+		}
+	}
+	return c, nil
+}
+
+// compactLoc converts entry, NOTE: strings are interned in the order of compactLoc (see compactReader.dbEntry)
+func (w *compactWriter) compactLoc(entry dbEntry) (compactLoc, error) {
+	data := entry.Loc
+	c := compactLoc{
+		Kind:     compactLocKind,
+		Child:    data.Child,
+		Mode:     data.Mode,
+		Symlink:  data.Symlink,
+		UserId:   data.UserId,
+		GroupId:  data.GroupId,
+		DevMajor: data.DevMajor,
+		DevMinor: data.DevMinor,
+		Xattrs:   data.Xattrs,
+	}
+	c.Parent = w.intern(entry.Parent)
+	c.Uid = w.intern(entry.Uid)
+	c.Name = w.intern(data.Name)
+	c.User = w.intern(data.User)
+	c.Group = w.intern(data.Group)
+	c.Link = w.intern(data.Link)
+
+	for _, t := range []struct {
+		dst *[]byte
+		src time.Time
+	}{{&c.ModTime, data.ModTime}, {&c.Access, timeValue(data.AccessTime)}, {&c.Change, timeValue(data.ChangeTime)}, {&c.Birth, timeValue(data.BirthTime)}} {
+		if t.src.IsZero() {
+			continue
+		}
+		var err error
+		if *t.dst, err = t.src.MarshalBinary(); err != nil {
+			return compactLoc{}, err
+		}
+	}
+	return c, nil
+}
+
+// ------------------------------Save--------------------------------
+
+// ------------------------------Load--------------------------------
+type compactReader struct {
+	dec     *cbor.Decoder
+	strings []string
+	// the items before the trailer are hashed as theyre read (see verify)
+	h     hash.Hash
+	count int
+}
+
+// newCompactReader returns a reader for the items after compactMagic
+func newCompactReader(r io.Reader) (*compactReader, error) {
+	magic := make([]byte, len(compactMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, compactMagic) {
+		return nil, fmt.Errorf("%w: not compact", ErrCorruptDB)
+	}
+	return &compactReader{dec: cbor.NewDecoder(r), h: sha256.New()}, nil
+}
+
+// next returns the next item and true if its the trailer, io.EOF if there arent any more
+func (r *compactReader) next() (cbor.RawMessage, bool, error) {
+	var raw cbor.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+		return nil, false, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	// the entries are arrays and the trailer is a map (major type 5)
+	if raw[0]>>5 == 5 {
+		return raw, true, nil
+	}
+	r.h.Write(raw)
+	r.count++
+	return raw, false, nil
+}
+
+// verify checks the items read (header and entries) match the trailer returning its checksum
+func (r *compactReader) verify(raw cbor.RawMessage) (string, error) {
+	trailer := dbTrailer{}
+	if err := cbor.Unmarshal(raw, &trailer); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	if _, _, err := r.next(); !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("%w: data after trailer", ErrCorruptDB)
+	}
+	if trailer.Count != r.count || trailer.Checksum != hex.EncodeToString(r.h.Sum(nil)) {
+		return "", fmt.Errorf("%w: checksum doesnt match", ErrCorruptDB)
+	}
+	return trailer.Checksum, nil
+}
+
+// header returns the header (see parseHeader) which must be first
+func (r *compactReader) header() (DBHeader, error) {
+	raw, _, err := r.next()
+	if err != nil {
+		return DBHeader{}, fmt.Errorf("error reading header - %w", err)
+	}
+	var header []byte
+	if err := cbor.Unmarshal(raw, &header); err != nil {
+		return DBHeader{}, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	h, ok, err := parseHeader(header)
+	// format 1 (without a header) is only json lines
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: missing header", ErrCorruptDB)
+	}
+	return h, err
+}
+
+// resolve returns the string interned (see compactWriter.intern)
+func (r *compactReader) resolve(v any) (string, error) {
+	switch s := v.(type) {
+	case string:
+		if s != "" {
+			r.strings = append(r.strings, s)
+		}
+		return s, nil
+	case uint64:
+		if s < uint64(len(r.strings)) {
+			return r.strings[s], nil
+		}
+	}
+	return "", fmt.Errorf("%w: unknown string %v", ErrCorruptDB, v)
+}
+
+// dbEntry converts a compactRef or compactLoc, NOTE: strings are resolved in the order
+// they are in the item (see compactWriter.compactRef and compactWriter.compactLoc)
+func (r *compactReader) dbEntry(raw cbor.RawMessage) (dbEntry, error) {
+	var item []cbor.RawMessage
+	var kind uint8
+	if err := cbor.Unmarshal(raw, &item); err != nil || len(item) == 0 {
+		return dbEntry{}, fmt.Errorf("%w: entry isnt an array", ErrCorruptDB)
+	}
+	if err := cbor.Unmarshal(item[0], &kind); err != nil {
+		return dbEntry{}, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+
+	switch kind {
+	case compactRefKind:
+		c := compactRef{}
+		if err := cbor.Unmarshal(raw, &c); err != nil {
+			return dbEntry{}, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+		}
+		data, err := r.jsonRef(c)
+		return dbEntry{Ref: &data}, err
+	case compactLocKind:
+		c := compactLoc{}
+		if err := cbor.Unmarshal(raw, &c); err != nil {
+			return dbEntry{}, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+		}
+		entry := dbEntry{Loc: &jsonLoc{
+			Child:    c.Child,
+			Mode:     c.Mode,
+			Symlink:  c.Symlink,
+			UserId:   c.UserId,
+			GroupId:  c.GroupId,
+			DevMajor: c.DevMajor,
+			DevMinor: c.DevMinor,
+			Xattrs:   c.Xattrs,
+		}}
+		data := entry.Loc
+		if err := r.resolveAll(&entry.Parent, c.Parent, &entry.Uid, c.Uid, &data.Name, c.Name, &data.User, c.User, &data.Group, c.Group, &data.Link, c.Link); err != nil {
+			return entry, err
+		}
+
+		if err := unmarshalTime(&data.ModTime, c.ModTime); err != nil {
+			return entry, err
+		}
+		for _, t := range []struct {
+			dst **time.Time
+			src []byte
+		}{{&data.AccessTime, c.Access}, {&data.ChangeTime, c.Change}, {&data.BirthTime, c.Birth}} {
+			if t.src == nil {
+				continue
+			}
+			*t.dst = &time.Time{}
+			if err := unmarshalTime(*t.dst, t.src); err != nil {
+				return entry, err
+			}
+		}
+		return entry, nil
+	default:
+		return dbEntry{}, fmt.Errorf("%w: unknown entry %v", ErrCorruptDB, kind)
+	}
+}
+
+// resolveAll resolves pairs of where to put the string and the string interned (see resolve)
+func (r *compactReader) resolveAll(pairs ...any) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s, err := r.resolve(pairs[i+1])
+		if err != nil {
+			return err
+		}
+		*pairs[i].(*string) = s
+	}
+	return nil
+}
+
+// jsonRef converts c (see compactWriter.compactRef)
+func (r *compactReader) jsonRef(c compactRef) (jsonRef, error) {
+	data := jsonRef{
+		Size:    c.Size,
+		MD5:     hex.EncodeToString(c.MD5),
+		SHA1:    hex.EncodeToString(c.SHA1),
+		SHA256:  hex.EncodeToString(c.SHA256),
+		SHA512:  hex.EncodeToString(c.SHA512),
+		Entropy: c.Entropy,
+		Offset:  c.Offset,
+	}
+	var typ string
+	if err := r.resolveAll(&data.Uid, c.Uid, &typ, c.Type); err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal([]byte(typ), &data.Type); err != nil {
+		return data, fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	data.Tags = make(map[string]tagValue)
+	for _, tag := range c.Tags {
+		key, err := r.resolve(tag.Key)
+		if err != nil {
+			return data, err
+		}
+		value := tagValue{}
+		if err := json.Unmarshal(tag.Value, &value); err != nil {
+			return data, fmt.Errorf("%w: tag %v - %v", ErrCorruptDB, key, err)
+		}
+		data.Tags[key] = value
+	}
+	if err := r.resolveAll(&data.Source, c.Source); err != nil {
+		return data, err
+	}
+	for _, cd := range c.Diagnostics {
+		d := jsonDiagnostic{Message: cd.Message, Offset: cd.Offset}
+		var severity string
+		if err := r.resolveAll(&d.Code, cd.Code, &severity, cd.Severity, &d.Extractor, cd.Extractor); err != nil {
+			return data, err
+		}
+		d.Severity = Severity(severity)
+		if err := unmarshalTime(&d.Time, cd.Time); err != nil {
+			return data, err
+		}
+		data.Diagnostics = append(data.Diagnostics, d)
+	}
+	return data, nil
+}
+
+// unmarshalTime sets t from b (see time.MarshalBinary), zero if b is empty
+func unmarshalTime(t *time.Time, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := t.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	return nil
+}
+
+// loadCompact loads a compact db (see compactMagic) checking it as its read like loadFrom
+func (v *Fs) loadCompact(file io.Reader) error {
+	r, err := newCompactReader(file)
+	if err != nil {
+		return err
+	}
+	header, err := r.header()
+	if err != nil {
+		return err
+	}
+	if err := v.db.checkStorage(header); err != nil {
+		return err
+	}
+	// in case a previous load failed part way
+	v.db.refMap = make(map[string]*reference)
+	v.db.blobs = make(map[string]int)
+	v.db.err, v.db.warn = false, false
+	v.db.header = header
+
+	from := header.Format
+	checksum := ""
+	err = v.loadEntries(func() (dbEntry, bool, error) {
+		raw, isTrailer, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return dbEntry{}, false, fmt.Errorf("%w: missing trailer", ErrCorruptDB)
+		}
+		if err != nil {
+			return dbEntry{}, false, err
+		}
+		if isTrailer {
+			checksum, err = r.verify(raw)
+			return dbEntry{}, false, err
+		}
+		entry, err := r.dbEntry(raw)
+		if err != nil || from == dbFormat {
+			return entry, true, err
+		}
+		entry, err = migrateEntry(from, entry)
+		return entry, true, err
+	})
+	if err != nil {
+		return err
+	}
+	v.db.checksum = checksum
+	return nil
+}
+
+// migrateEntry upgrades entry from format (see dbMigrations)
+func migrateEntry(format int, entry dbEntry) (dbEntry, error) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	if line, err = migrate(line, format, dbFormat); err != nil {
+		return entry, fmt.Errorf("error migrating from format %v - %w", format, err)
+	}
+	migrated := dbEntry{}
+	return migrated, json.Unmarshal(line, &migrated)
+}
+
+// ------------------------------Load--------------------------------
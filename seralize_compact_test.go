@@ -0,0 +1,99 @@
+package virtualfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactDB(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v := newMetadataFs(t, tmp)
+		expected := treeLines(t, v)
+		fatalfIfErr(t, v.Close(), "failed to close")
+		jsonDB, err := os.ReadFile(filepath.Join(tmp, finDB))
+		fatalfIfErr(t, err, "failed to read json db")
+
+		//------------ Switch to compact
+		v, err = NewFsFromDb(tmp, WithCompactDB())
+		fatalfIfErr(t, err, "failed to load json db")
+		fatalfIfErr(t, v.Close(), "failed to close compact")
+		compactDB, err := os.ReadFile(filepath.Join(tmp, finDB))
+		fatalfIfErr(t, err, "failed to read compact db")
+		assert(t, bytes.HasPrefix(compactDB, compactMagic), "should save compact db")
+		assert(t, len(compactDB) < len(jsonDB)/2, "compact db should be smaller %v >= %v", len(compactDB), len(jsonDB))
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load compact db")
+		assertEqual(t, expected, treeLines(t, v), "loaded tree should match")
+		assertErr(t, ErrInFilesystem, v.FsError(), "should load errors")
+		assertErr(t, ErrInFilesystem, v.FsWarning(), "should load warnings")
+		assert(t, v.DBHeader().Storage.Compact, "header should say compact")
+		assertContent(t, "Hello, World!", v, "/foo/bar")
+
+		//------------ Switch back to json
+		fatalfIfErr(t, v.Close(), "failed to close json")
+		db, err := os.ReadFile(filepath.Join(tmp, finDB))
+		fatalfIfErr(t, err, "failed to read db")
+		assertEqual(t, string(jsonDB), string(db), "json db should be the same as before")
+	})
+}
+
+func TestCompactDBJournal(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithCompactDB(), WithJournal(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/foo/bar", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /foo/bar")
+
+		//------------ Crash (no Close) and replay on the compact snapshot
+		crash(t, v)
+		v, err = NewFsFromDb(tmp, WithCompactDB(), WithJournal(0))
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertContent(t, "Hello, World!", v, "/foo/bar")
+		fatalfIfErr(t, v.Close(), "failed to close")
+	})
+}
+
+func TestLoadCorruptCompactDB(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithCompactDB())
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/first", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /first")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp, WithCompactDB())
+		fatalfIfErr(t, err, "failed to load")
+		err = createFile(v, "/second", 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /second")
+		fatalfIfErr(t, v.Close(), "failed to close again")
+		db, err := os.ReadFile(filepath.Join(tmp, finDB))
+		fatalfIfErr(t, err, "failed to read db")
+
+		//------------ Truncated db falls back to the previous one
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, finDB), db[:len(db)-10], 0644), "failed to truncate db")
+		err = (&Fs{db: v.db}).loadFrom(finDB)
+		assertErr(t, ErrCorruptDB, err, "truncated db should be corrupt")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load previous db")
+		assertErr(t, ErrInFilesystem, v.FsWarning(), "should warn that the previous db was loaded")
+		assertContent(t, "Hello, World!", v, "/first")
+		_, err = v.Stat("/second")
+		assertErr(t, ErrNotFound, err, "previous db shouldnt have /second")
+
+		//------------ Changed db
+		db[len(db)/2] ^= 0xff
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, finDB), db, 0644), "failed to change db")
+		err = (&Fs{db: v.db}).loadFrom(finDB)
+		assertErr(t, ErrCorruptDB, err, "changed db should be corrupt")
+	})
+}
+
+func TestCompactDBUnsupported(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		_, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithCompactDB(), WithSQLite())
+		assertErr(t, ErrCompactSQLite, err, "sqlite doesnt save fin.db")
+	})
+}
@@ -0,0 +1,691 @@
+package virtualfs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+var ErrReadOnly = fmt.Errorf("read only")
+
+// ErrSQLiteEncrypted the sqlite db isnt encrypted like fin.db
+var ErrSQLiteEncrypted = fmt.Errorf("sqlite cant be used with encryption")
+var ErrSQLiteUnsupported = fmt.Errorf("sqlite needs a storage dir")
+
+const finSQLite = "fin.sqlite"
+
+const sqliteSchema = `
+CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE refs (
+	id TEXT PRIMARY KEY,
+	size INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	mimetype TEXT NOT NULL,
+	md5 TEXT NOT NULL,
+	sha1 TEXT NOT NULL,
+	sha256 TEXT NOT NULL,
+	sha512 TEXT NOT NULL,
+	entropy REAL NOT NULL,
+	source TEXT NOT NULL,
+	section_offset INTEGER NOT NULL
+);
+CREATE TABLE nodes (
+	id INTEGER PRIMARY KEY,
+	parent INTEGER REFERENCES nodes (id),
+	child INTEGER NOT NULL,
+	layer INTEGER NOT NULL,
+	path TEXT NOT NULL,
+	name TEXT NOT NULL,
+	mode INTEGER NOT NULL,
+	mod_time TEXT NOT NULL,
+	symlink TEXT NOT NULL,
+	ref_id TEXT NOT NULL REFERENCES refs (id),
+	attrs TEXT NOT NULL
+);
+CREATE TABLE tags (ref_id TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL, PRIMARY KEY (ref_id, key));
+CREATE TABLE diagnostics (
+	ref_id TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	code TEXT NOT NULL,
+	severity TEXT NOT NULL,
+	message TEXT NOT NULL,
+	extractor TEXT NOT NULL,
+	offset INTEGER NOT NULL,
+	time TEXT NOT NULL,
+	PRIMARY KEY (ref_id, seq)
+);
+CREATE INDEX nodes_path ON nodes (path, layer);
+CREATE INDEX nodes_parent ON nodes (parent);
+CREATE INDEX refs_md5 ON refs (md5);
+CREATE INDEX refs_sha1 ON refs (sha1);
+CREATE INDEX refs_sha256 ON refs (sha256);
+CREATE INDEX refs_sha512 ON refs (sha512);
+CREATE INDEX tags_key ON tags (key);
+CREATE INDEX diagnostics_code ON diagnostics (code);
+`
+
+// sqliteAttrs are the optional node attributes (see jsonFs) stored as json
+type sqliteAttrs struct {
+	UserId     int               `json:"userId,omitempty"`
+	GroupId    int               `json:"groupId,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Group      string            `json:"group,omitempty"`
+	AccessTime *time.Time        `json:"accessTime,omitempty"`
+	ChangeTime *time.Time        `json:"changeTime,omitempty"`
+	BirthTime  *time.Time        `json:"birthTime,omitempty"`
+	DevMajor   int64             `json:"devMajor,omitempty"`
+	DevMinor   int64             `json:"devMinor,omitempty"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+	Link       string            `json:"link,omitempty"`
+}
+
+// ------------------------------Save--------------------------------
+// writeSQLite writes the tree to fin.sqlite returning an id for the snapshot (see journal)
+func (v *Fs) writeSQLite() (string, error) {
+	path := filepath.Join(v.db.dbDir, finSQLite)
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	snapshot, err := v.writeSQLiteTo(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := syncFile(tmp); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	return snapshot, syncDir(v.db.dbDir)
+}
+
+func (v *Fs) writeSQLiteTo(path string) (string, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return "", fmt.Errorf("error opening sqlite - %w", err)
+	}
+	defer db.Close()
+	// the file is renamed once written so it doesnt need its own journal
+	if _, err := db.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF;" + sqliteSchema); err != nil {
+		return "", fmt.Errorf("error creating sqlite schema - %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	w := &sqliteWriter{refs: make(map[string]bool), walked: make(map[string]bool)}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.node, "INSERT INTO nodes (parent, child, layer, path, name, mode, mod_time, symlink, ref_id, attrs) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&w.ref, "INSERT INTO refs (id, size, type, mimetype, md5, sha1, sha256, sha512, entropy, source, section_offset) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&w.tag, "INSERT INTO tags (ref_id, key, value) VALUES (?, ?, ?)"},
+		{&w.diagnostic, "INSERT INTO diagnostics (ref_id, seq, code, severity, message, extractor, offset, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"},
+	}
+	for _, s := range statements {
+		if *s.stmt, err = tx.Prepare(s.query); err != nil {
+			return "", err
+		}
+	}
+	if err := w.insert(v, nil, 0, "/", false); err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(v.db.newHeader())
+	if err != nil {
+		return "", fmt.Errorf("error marshalling header - %w", err)
+	}
+	snapshot := uuid.New().String()
+	if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES ('header', ?), ('snapshot', ?)", string(header), snapshot); err != nil {
+		return "", err
+	}
+	return snapshot, tx.Commit()
+}
+
+type sqliteWriter struct {
+	node, ref, tag, diagnostic *sql.Stmt
+	refs                       map[string]bool
+	// references whose layers or children are inserted, a reference is only inserted once
+	// but might be the source of a section before its location is (see insertRef)
+	walked map[string]bool
+}
+
+// insert inserts n in the same order as walkRecursive and the first time its reference is at a location
+// its layers or children, hardlinks share them again when loaded (see dbWriter.writeLoc)
+func (w *sqliteWriter) insert(n *Fs, parent *int64, layer int, path string, child bool) error {
+	if err := w.insertRef(n.ref); err != nil {
+		return err
+	}
+	first := !w.walked[n.ref.id]
+	w.walked[n.ref.id] = true
+	attrs, err := json.Marshal(sqliteAttrs{
+		UserId:     n.uid,
+		GroupId:    n.gid,
+		User:       n.uname,
+		Group:      n.gname,
+		AccessTime: timePtr(n.times.Access),
+		ChangeTime: timePtr(n.times.Change),
+		BirthTime:  timePtr(n.times.Birth),
+		DevMajor:   n.devMajor,
+		DevMinor:   n.devMinor,
+		Xattrs:     n.xattrs,
+		Link:       n.link,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling attributes %v - %w", path, err)
+	}
+	res, err := w.node.Exec(parent, child, layer, path, n.name, uint32(n.mode), n.modTime.Format(time.RFC3339Nano), n.symlinkPath, n.ref.id, string(attrs))
+	if err != nil {
+		return fmt.Errorf("error inserting %v - %w", path, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if !first {
+		return nil
+	}
+
+	if n.ref.child != nil {
+		return w.insert(n.ref.child, &id, layer+1, path, true)
+	}
+	names := make([]string, 0, len(n.ref.children))
+	for name := range n.ref.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := w.insert(n.ref.children[name], &id, 0, filepath.Join(path, name), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRef inserts the reference (with its tags and diagnostics) the first time its seen
+func (w *sqliteWriter) insertRef(ref *reference) error {
+	if w.refs[ref.id] {
+		return nil
+	}
+	w.refs[ref.id] = true
+	// the source of a section might not be in the tree anymore
+	if ref.source != nil {
+		if err := w.insertRef(ref.source); err != nil {
+			return err
+		}
+	}
+
+	typ, err := json.Marshal(ref.typ)
+	if err != nil {
+		return err
+	}
+	source := ""
+	if ref.source != nil {
+		source = ref.source.id
+	}
+	_, err = w.ref.Exec(ref.id, ref.size, string(typ), ref.typ.Mimetype, ref.md5, ref.sha1, ref.sha256, ref.sha512, ref.entropy, source, ref.offset)
+	if err != nil {
+		return fmt.Errorf("error inserting reference %v - %w", ref.id, err)
+	}
+
+	ref.tags.Range(func(key, value any) bool {
+		var tag []byte
+		if tag, err = json.Marshal(tagValue{value}); err != nil {
+			return false
+		}
+		_, err = w.tag.Exec(ref.id, key, string(tag))
+		return err == nil
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting tags %v - %w", ref.id, err)
+	}
+	for i, d := range append(slices.Clone(ref.errs), ref.warns...) {
+		_, err := w.diagnostic.Exec(ref.id, i, d.Code, string(d.Severity), d.Error(), d.Extractor, d.Offset, d.Time.Format(time.RFC3339Nano))
+		if err != nil {
+			return fmt.Errorf("error inserting diagnostics %v - %w", ref.id, err)
+		}
+	}
+	return nil
+}
+
+// syncFile fsyncs the file at path
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// ------------------------------Save--------------------------------
+
+// ------------------------------Load--------------------------------
+const sqliteSelect = `SELECT n.id, n.parent, n.child, n.path, n.name, n.mode, n.mod_time, n.symlink, n.attrs,
+	r.id, r.size, r.type, r.md5, r.sha1, r.sha256, r.sha512, r.entropy, r.source, r.section_offset
+	FROM nodes n JOIN refs r ON r.id = n.ref_id`
+
+// sqliteEntry is a node read from fin.sqlite
+type sqliteEntry struct {
+	id     int64
+	parent sql.NullInt64
+	data   jsonFs
+}
+
+// sqliteReader reads fin.sqlite
+type sqliteReader struct {
+	db *sql.DB
+}
+
+func openSQLite(path string) (*sqliteReader, DBHeader, string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, DBHeader{}, "", err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, DBHeader{}, "", fmt.Errorf("error opening sqlite - %w", err)
+	}
+	r := &sqliteReader{db: db}
+
+	var header, snapshot string
+	err = db.QueryRow("SELECT value FROM meta WHERE key = 'header'").Scan(&header)
+	if err == nil {
+		err = db.QueryRow("SELECT value FROM meta WHERE key = 'snapshot'").Scan(&snapshot)
+	}
+	if err != nil {
+		db.Close()
+		return nil, DBHeader{}, "", fmt.Errorf("%w: %v", ErrCorruptDB, err)
+	}
+	h, _, err := parseHeader([]byte(header))
+	if err != nil {
+		db.Close()
+		return nil, DBHeader{}, "", err
+	}
+	return r, h, snapshot, nil
+}
+
+// entries returns the nodes (ordered so parents are before their children) selected by where
+func (r *sqliteReader) entries(where string, args ...any) ([]sqliteEntry, error) {
+	rows, err := r.db.Query(sqliteSelect+" "+where+" ORDER BY n.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []sqliteEntry{}
+	for rows.Next() {
+		e := sqliteEntry{}
+		var modTime, attrs, typ string
+		d := &e.data
+		err := rows.Scan(&e.id, &e.parent, &d.Child, &d.Path, &d.Name, &d.Mode, &modTime, &d.Symlink, &attrs,
+			&d.Uid, &d.Size, &typ, &d.MD5, &d.SHA1, &d.SHA256, &d.SHA512, &d.Entropy, &d.Source, &d.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if d.ModTime, err = time.Parse(time.RFC3339Nano, modTime); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(typ), &d.Type); err != nil {
+			return nil, err
+		}
+		a := sqliteAttrs{}
+		if err := json.Unmarshal([]byte(attrs), &a); err != nil {
+			return nil, err
+		}
+		d.UserId, d.GroupId, d.User, d.Group = a.UserId, a.GroupId, a.User, a.Group
+		d.AccessTime, d.ChangeTime, d.BirthTime = a.AccessTime, a.ChangeTime, a.BirthTime
+		d.DevMajor, d.DevMinor, d.Xattrs, d.Link = a.DevMajor, a.DevMinor, a.Xattrs, a.Link
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, r.addTagsAndDiagnostics(entries)
+}
+
+// ref returns the reference saved with id (with its tags and diagnostics)
+func (r *sqliteReader) ref(id string) (jsonRef, error) {
+	e := []sqliteEntry{{}}
+	d := &e[0].data
+	var typ string
+	err := r.db.QueryRow("SELECT id, size, type, md5, sha1, sha256, sha512, entropy, source, section_offset FROM refs WHERE id = ?", id).
+		Scan(&d.Uid, &d.Size, &typ, &d.MD5, &d.SHA1, &d.SHA256, &d.SHA512, &d.Entropy, &d.Source, &d.Offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jsonRef{}, fmt.Errorf("%w: unknown reference %v", ErrCorruptDB, id)
+	}
+	if err != nil {
+		return jsonRef{}, err
+	}
+	if err := json.Unmarshal([]byte(typ), &d.Type); err != nil {
+		return jsonRef{}, err
+	}
+	return d.jsonRef, r.addTagsAndDiagnostics(e)
+}
+
+// link loads the sources of sections that arent in the entries loaded, the source of a section
+// might not be in the tree (or the part of it selected), then links them (see refLoader.link)
+func (r *sqliteReader) link(refs *refLoader) error {
+	for missing := refs.missing(); len(missing) > 0; missing = refs.missing() {
+		for _, id := range missing {
+			data, err := r.ref(id)
+			if err != nil {
+				return err
+			}
+			refs.add(data)
+		}
+	}
+	return refs.link()
+}
+
+func (r *sqliteReader) addTagsAndDiagnostics(entries []sqliteEntry) error {
+	tags, err := r.db.Prepare("SELECT key, value FROM tags WHERE ref_id = ?")
+	if err != nil {
+		return err
+	}
+	defer tags.Close()
+	diagnostics, err := r.db.Prepare("SELECT code, severity, message, extractor, offset, time FROM diagnostics WHERE ref_id = ? ORDER BY seq")
+	if err != nil {
+		return err
+	}
+	defer diagnostics.Close()
+
+	for i := range entries {
+		d := &entries[i].data
+		d.Tags = make(map[string]tagValue)
+		rows, err := tags.Query(d.Uid)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key, value string
+			tag := tagValue{}
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := json.Unmarshal([]byte(value), &tag); err != nil {
+				rows.Close()
+				return err
+			}
+			d.Tags[key] = tag
+		}
+		rows.Close()
+
+		if d.Diagnostics, err = r.diagnostics(diagnostics, d.Uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sqliteReader) diagnostics(stmt *sql.Stmt, id string) ([]jsonDiagnostic, error) {
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagnostics := []jsonDiagnostic{}
+	for rows.Next() {
+		d := jsonDiagnostic{}
+		var at string
+		if err := rows.Scan(&d.Code, &d.Severity, &d.Message, &d.Extractor, &d.Offset, &at); err != nil {
+			return nil, err
+		}
+		if d.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, err
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics, rows.Err()
+}
+
+// build builds the tree from entries into top (the first entry)
+func (r *sqliteReader) build(top *Fs, entries []sqliteEntry) error {
+	refs := newRefLoader(top.db)
+	nodes := make(map[int64]*Fs)
+	for i, e := range entries {
+		n := top
+		if i > 0 {
+			n = &Fs{db: top.db}
+		}
+		setFsFromJson(n, e.data.jsonLoc)
+		n.ref = refs.add(e.data.jsonRef)
+		nodes[e.id] = n
+
+		if i == 0 {
+			continue
+		}
+		parent, ok := nodes[e.parent.Int64]
+		if !ok {
+			return fmt.Errorf("%w: parent of %v", ErrCorruptDB, e.data.Path)
+		}
+		if e.data.Child {
+			parent.ref.child = n
+		} else {
+			parent.ref.children[n.name] = n
+		}
+	}
+	return r.link(refs)
+}
+
+// loadSQLite loads the whole tree from fin.sqlite
+func (v *Fs) loadSQLite() error {
+	r, header, snapshot, err := openSQLite(filepath.Join(v.db.dbDir, finSQLite))
+	if err != nil {
+		return err
+	}
+	defer r.db.Close()
+	if err := v.db.checkStorage(header); err != nil {
+		return err
+	}
+
+	entries, err := r.entries("")
+	if err != nil {
+		return fmt.Errorf("error reading sqlite - %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%w: no root", ErrCorruptDB)
+	}
+	v.db.refMap = make(map[string]*reference)
+	v.db.blobs = make(map[string]int)
+	v.db.err, v.db.warn = false, false
+	v.db.header = header
+	v.db.checksum = snapshot
+	return r.build(v, entries)
+}
+
+// ------------------------------Load--------------------------------
+
+// ------------------------------MetaDB--------------------------------
+// MetaDB queries fin.sqlite (see WithSQLite) without loading the whole tree. Nodes returned dont
+// have their children loaded (except Load) and are read only, files cant be added and nothing is saved
+type MetaDB struct {
+	r  *sqliteReader
+	db *referenceDB
+}
+
+// OpenMetaDB opens the fin.sqlite in storageDir, opts are the options the storage dir was created with
+func OpenMetaDB(storageDir string, opts ...Option) (*MetaDB, error) {
+	db, err := newReferenceDB(storageDir, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return openMetaDB(db)
+}
+
+// OpenMetaDB opens the fin.sqlite of a root in the catalog (see OpenMetaDB)
+func (c *Catalog) OpenMetaDB(root string) (*MetaDB, error) {
+	db, err := c.newReferenceDB(root)
+	if err != nil {
+		return nil, err
+	}
+	return openMetaDB(db)
+}
+
+func openMetaDB(db *referenceDB) (*MetaDB, error) {
+	r, header, _, err := openSQLite(filepath.Join(db.dbDir, finSQLite))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkStorage(header); err != nil {
+		r.db.Close()
+		return nil, err
+	}
+	db.store = readOnlyStore{db.store}
+	db.header = header
+	return &MetaDB{r: r, db: db}, nil
+}
+
+// Close closes the sqlite db
+func (m *MetaDB) Close() error {
+	return m.r.db.Close()
+}
+
+// Header returns the header of the db
+func (m *MetaDB) Header() DBHeader {
+	return m.db.header
+}
+
+// newDB returns a db for nodes so queries dont share references
+func (m *MetaDB) newDB() *referenceDB {
+	return &referenceDB{storageDir: m.db.storageDir, store: m.db.store, dbDir: m.db.dbDir, dbStore: m.db.dbStore, header: m.db.header, refMap: make(map[string]*reference), blobs: make(map[string]int)}
+}
+
+// each calls callback with the path and node of each entry, loaded like the whole tree is (see build)
+// so entries with the same contents keep their own tags
+func (m *MetaDB) each(entries []sqliteEntry, callback func(string, *Fs) error) error {
+	db := m.newDB()
+	refs := newRefLoader(db)
+	nodes := make([]*Fs, len(entries))
+	for i, e := range entries {
+		nodes[i] = &Fs{db: db}
+		setFsFromJson(nodes[i], e.data.jsonLoc)
+		nodes[i].ref = refs.add(e.data.jsonRef)
+	}
+	if err := m.r.link(refs); err != nil {
+		return err
+	}
+	for i, n := range nodes {
+		if err := callback(entries[i].data.Path, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the last layer at path (see Fs.Stat)
+func (m *MetaDB) Stat(path string) (*Fs, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.r.entries("WHERE n.path = ? AND n.id = (SELECT max(id) FROM nodes WHERE path = ?)", path, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, path)
+	}
+	var n *Fs
+	return n, m.each(entries, func(_ string, found *Fs) error {
+		n = found
+		return nil
+	})
+}
+
+// ReadDir calls callback with the children (sorted) of the last layer at path
+func (m *MetaDB) ReadDir(path string, callback func(string, *Fs) error) error {
+	path, err := cleanPath(path)
+	if err != nil {
+		return err
+	}
+	entries, err := m.r.entries("WHERE n.child = 0 AND n.parent = (SELECT max(id) FROM nodes WHERE path = ?)", path)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b sqliteEntry) int {
+		return strings.Compare(a.data.Name, b.data.Name)
+	})
+	return m.each(entries, callback)
+}
+
+// FindHash calls callback with every node whose md5, sha1, sha256 or sha512 is hash
+func (m *MetaDB) FindHash(hash string, callback func(string, *Fs) error) error {
+	entries, err := m.r.entries("WHERE r.md5 = ? OR r.sha1 = ? OR r.sha256 = ? OR r.sha512 = ?", hash, hash, hash, hash)
+	if err != nil {
+		return err
+	}
+	return m.each(entries, callback)
+}
+
+// FindTag calls callback with every node tagged with key
+func (m *MetaDB) FindTag(key string, callback func(string, *Fs) error) error {
+	entries, err := m.r.entries("WHERE r.id IN (SELECT ref_id FROM tags WHERE key = ?)", key)
+	if err != nil {
+		return err
+	}
+	return m.each(entries, callback)
+}
+
+// Load loads path (all its layers) and everything under it
+func (m *MetaDB) Load(path string) (*Fs, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.r.entries(`WHERE n.id IN (
+		WITH RECURSIVE sub(id) AS (
+			SELECT min(id) FROM nodes WHERE path = ?
+			UNION ALL SELECT nodes.id FROM nodes JOIN sub ON nodes.parent = sub.id
+		) SELECT id FROM sub)`, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, path)
+	}
+	top := &Fs{db: m.newDB()}
+	return top, m.r.build(top, entries)
+}
+
+// cleanPath returns path how its stored (see walkRecursive)
+func cleanPath(path string) (string, error) {
+	paths, err := split(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/", filepath.Join(paths...)), nil
+}
+
+// readOnlyStore is a blobStore that cant add files and never removes them (see MetaDB)
+type readOnlyStore struct {
+	blobStore
+}
+
+func (readOnlyStore) create(name string) (destination, error) {
+	return nil, fmt.Errorf("%w: cant add %v", ErrReadOnly, name)
+}
+
+func (readOnlyStore) remove(name string) error {
+	return nil
+}
+
+// ------------------------------MetaDB--------------------------------
@@ -0,0 +1,205 @@
+package virtualfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newMetadataFs creates a fs with layers, links, tags, errors and attributes
+func newMetadataFs(t *testing.T, tmp string, opts ...Option) *Fs {
+	t.Helper()
+	v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, opts...)
+	fatalfIfErr(t, err, "failed to create virtual function")
+	v.TagS("foo", "bar")
+
+	err = createFile(v, "/foo/bar", 0655, time1, helloWorldCompressed)
+	fatalfIfErr(t, err, "failed to create /foo/bar")
+	bar, err := v.Stat("/foo/bar")
+	fatalfIfErr(t, err, "failed to stat /foo/bar")
+	err = createChildFile(bar, 0611, time1, "Hello, World!")
+	fatalfIfErr(t, err, "failed to create child of /foo/bar")
+	bar, err = v.Stat("/foo/bar")
+	fatalfIfErr(t, err, "failed to stat /foo/bar again")
+	bar.TagS("processed", "yes")
+	bar.Warning(fmt.Errorf("yikes"))
+
+	err = createFile(v, "/foo/other", 0644, time2, "Hello, Foo!")
+	fatalfIfErr(t, err, "failed to create /foo/other")
+	other, err := v.Stat("/foo/other")
+	fatalfIfErr(t, err, "failed to stat /foo/other")
+	other.Error(fmt.Errorf("bad file"))
+	_, err = v.Symlink("/foo/bar", "/link/symlink", 0777, time3)
+	fatalfIfErr(t, err, "failed to create symlink")
+	_, err = v.Hardlink("/foo/other", "/link/hardlink", 0644, time3)
+	fatalfIfErr(t, err, "failed to create hardlink")
+	fatalfIfErr(t, v.Chown("/foo/other", 1000, 1000), "failed to chown")
+	fatalfIfErr(t, v.Setxattr("/foo/other", XattrSELinux, []byte("system_u")), "failed to set xattr")
+	return v
+}
+
+func TestSQLite(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v := newMetadataFs(t, tmp, WithSQLite())
+		expected := treeLines(t, v)
+		fatalfIfErr(t, v.Close(), "failed to close")
+		_, err := os.Stat(filepath.Join(tmp, finSQLite))
+		fatalfIfErr(t, err, "should save fin.sqlite")
+		_, err = os.Stat(filepath.Join(tmp, finDB))
+		assert(t, os.IsNotExist(err), "shouldnt save fin.db")
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load fin.sqlite")
+		assertEqual(t, expected, treeLines(t, v), "loaded tree should match")
+		assertErr(t, ErrInFilesystem, v.FsError(), "should load errors")
+		assertErr(t, ErrInFilesystem, v.FsWarning(), "should load warnings")
+		assert(t, v.DBHeader().Storage.SQLite, "header should say sqlite")
+		assertContent(t, "Hello, World!", v, "/foo/bar")
+
+		//------------ Switching back to fin.db
+		fatalfIfErr(t, v.Close(), "failed to close again")
+		_, err = os.Stat(filepath.Join(tmp, finSQLite))
+		assert(t, os.IsNotExist(err), "fin.sqlite should be removed")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load fin.db")
+		assertEqual(t, expected, treeLines(t, v), "loaded tree should match after switching")
+	})
+}
+
+func TestMetaDB(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v := newMetadataFs(t, tmp, WithSQLite())
+		fatalfIfErr(t, v.Checkpoint(), "failed to checkpoint")
+
+		m, err := OpenMetaDB(tmp)
+		fatalfIfErr(t, err, "failed to open meta db")
+		defer m.Close()
+		assert(t, m.Header().Storage.SQLite, "header should say sqlite")
+
+		bar, err := m.Stat("foo/../foo/bar")
+		fatalfIfErr(t, err, "failed to stat /foo/bar")
+		assertEqual(t, helloWorldSha512, bar.Sha512(), "should stat the last layer")
+		assertEqual(t, "yes", fmt.Sprint(mustTag(t, bar, "processed")), "should have tags")
+		_, err = m.Stat("/missing")
+		assertErr(t, ErrNotFound, err, "should error if missing")
+
+		names := []string{}
+		err = m.ReadDir("/foo", func(path string, n *Fs) error {
+			names = append(names, path)
+			return nil
+		})
+		fatalfIfErr(t, err, "failed to read /foo")
+		assertEqual(t, "[/foo/bar /foo/other]", fmt.Sprint(names), "should list the children")
+
+		paths := []string{}
+		err = m.FindHash(helloFooSha512, func(path string, n *Fs) error {
+			paths = append(paths, path)
+			if path == "/foo/other" {
+				assertEqual(t, 1000, n.Uid(), "should have the owner")
+			}
+			return nil
+		})
+		fatalfIfErr(t, err, "failed to find hash")
+		assertEqual(t, "[/foo/other /link/hardlink]", fmt.Sprint(paths), "should find the hardlinks")
+
+		paths = []string{}
+		err = m.FindTag("processed", func(path string, n *Fs) error {
+			paths = append(paths, path)
+			return nil
+		})
+		fatalfIfErr(t, err, "failed to find tag")
+		assertEqual(t, "[/foo/bar]", fmt.Sprint(paths), "should find the tagged layer")
+
+		//------------ Load part of the tree
+		foo, err := m.Load("/foo")
+		fatalfIfErr(t, err, "failed to load /foo")
+		assertContent(t, "Hello, World!", foo, "/bar")
+		assertContent(t, "Hello, Foo!", foo, "/other")
+		_, err = foo.Stat("/missing")
+		assertErr(t, ErrNotFound, err, "should only load /foo")
+		err = createFile(foo, "/new", 0644, time1, "Hello, New!")
+		assertErr(t, ErrReadOnly, err, "loaded tree should be read only")
+		fatalfIfErr(t, foo.Remove("/other"), "failed to remove from loaded tree")
+		assertContent(t, "Hello, Foo!", v, "/foo/other")
+	})
+}
+
+func TestSQLiteHardlinkedDir(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithSQLite())
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/a/hello", 0644, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /a/hello")
+		_, err = v.Hardlink("/a", "/linked", 0755, time1)
+		fatalfIfErr(t, err, "failed to hardlink /a")
+		_, err = v.Hardlink("/a", "/a/b", 0755, time1)
+		fatalfIfErr(t, err, "failed to hardlink /a into itself")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load fin.sqlite")
+		assertContent(t, "Hello, World!", v, "/linked/hello")
+		assertContent(t, "Hello, World!", v, "/a/b/b/hello")
+		a, err := v.Stat("/a")
+		fatalfIfErr(t, err, "failed to stat /a")
+		linked, err := v.Stat("/linked")
+		fatalfIfErr(t, err, "failed to stat /linked")
+		assert(t, a.ref == linked.ref, "hardlinked dirs should share the reference")
+	})
+}
+
+func TestMetaDBSameContents(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithSQLite())
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/first", 0644, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /first")
+		err = createFile(v, "/second", 0644, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /second")
+		first, err := v.Stat("/first")
+		fatalfIfErr(t, err, "failed to stat /first")
+		second, err := v.Stat("/second")
+		fatalfIfErr(t, err, "failed to stat /second")
+		first.TagS("name", "first")
+		second.TagS("name", "second")
+		// references saved separately load separately even with the same contents
+		second.ref.sha512 = first.ref.sha512
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		m, err := OpenMetaDB(tmp)
+		fatalfIfErr(t, err, "failed to open meta db")
+		defer m.Close()
+		tags := []string{}
+		err = m.FindHash(helloWorldSha512, func(path string, n *Fs) error {
+			tags = append(tags, path+"="+fmt.Sprint(mustTag(t, n, "name")))
+			return nil
+		})
+		fatalfIfErr(t, err, "failed to find hash")
+		assertEqual(t, "[/first=first /second=second]", fmt.Sprint(tags), "should keep the tags of each")
+
+		tags = []string{}
+		err = m.FindTag("name", func(path string, n *Fs) error {
+			tags = append(tags, path+"="+fmt.Sprint(mustTag(t, n, "name")))
+			return nil
+		})
+		fatalfIfErr(t, err, "failed to find tag")
+		assertEqual(t, "[/first=first /second=second]", fmt.Sprint(tags), "should keep the tags of each")
+	})
+}
+
+func TestSQLiteUnsupported(t *testing.T) {
+	_, err := newFooMemFs(WithSQLite())
+	assertErr(t, ErrSQLiteUnsupported, err, "in memory fs cant use sqlite")
+	tmpDir(t, func(tmp string) {
+		_, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithSQLite(), WithEncryption(testKey))
+		assertErr(t, ErrSQLiteEncrypted, err, "encrypted fs cant use sqlite")
+	})
+}
+
+func mustTag(t *testing.T, n *Fs, key string) any {
+	t.Helper()
+	value, ok := n.TagG(key)
+	assert(t, ok, "should have tag %v", key)
+	return value
+}
@@ -1,10 +1,15 @@
 package virtualfs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -81,7 +86,7 @@ func TestVirtualCloseOG(t *testing.T) {
 		newV, err := NewFsFromDb(tmp)
 		fatalfIfErr(t, err, "failed to create filesystem from dir")
 		assertFiles(t, expected, newV, "after loading files in virtual from")
-		assertTmpDirFileCount(t, 4, tmp, "after loading fs should delete manifest")
+		assertTmpDirFileCount(t, 5, tmp, "after loading fs should keep manifest")
 
 		_, err = newV.MkdirP("/foo/new-folder", 0155, time1)
 		fatalfIfErr(t, err, "failed to create virtual folder /foo/new-folder")
@@ -102,7 +107,7 @@ func TestVirtualCloseOG(t *testing.T) {
 			fileinfoTest{"/foo/new-folder", 0155 | fs.ModeDir, time1, "", "directory/directory", "", emptyTags},
 		)
 		assertFiles(t, newExpected, newV, "after creating files in virtual from")
-		assertTmpDirFileCount(t, 4, tmp, "after creating in virtual from")
+		assertTmpDirFileCount(t, 5, tmp, "after creating in virtual from")
 		fatalfIfErr(t, newV.FsError(), "should NOT have error on load if it wasnt set before save")
 		assertErr(t, ErrInFilesystem, newV.FsWarning(), "should have warning when loading")
 	})
@@ -161,7 +166,7 @@ func TestVirtualCloseWithErr(t *testing.T) {
 		//------------ Load folder and make sure it works
 		newV, err := NewFsFromDb(tmp)
 		fatalfIfErr(t, err, "failed to create filesystem from dir")
-		assertTmpDirFileCount(t, 2, tmp, "after loading fs should delete manifest")
+		assertTmpDirFileCount(t, 3, tmp, "after loading fs should keep manifest")
 
 		_, err = newV.MkdirP("/foo/new-folder", 0155, time1)
 		fatalfIfErr(t, err, "failed to create virtual folder /foo/new-folder")
@@ -180,8 +185,269 @@ func TestVirtualCloseWithErr(t *testing.T) {
 			fileinfoTest{"/foo/new-folder", 0155 | fs.ModeDir, time1, "", "directory/directory", "", emptyTags},
 		)
 		assertFiles(t, newExpected, newV, "after creating files in virtual from")
-		assertTmpDirFileCount(t, 3, tmp, "after creating in virtual from")
+		assertTmpDirFileCount(t, 4, tmp, "after creating in virtual from")
 		assertErr(t, newV.FsError(), ErrInFilesystem, "should have error when loading")
 		assert(t, newV.FsWarning() == nil, "should NOT have warning after loading if wasnt set")
 	})
 }
+
+func TestReopen(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		//------------ Stage 1
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/stage1", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /stage1")
+		fatalfIfErr(t, v.Close(), "failed to close stage 1")
+
+		//------------ Stage 2 crashes before closing
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load for stage 2")
+		err = createFile(v, "/crashed", 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /crashed")
+
+		//------------ Stage 2 again
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after crash")
+		_, err = v.Stat("/crashed")
+		assertErr(t, ErrNotFound, err, "crashed stage shouldnt be saved")
+		err = createFile(v, "/stage2/duplicate", 0655, time2, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /stage2/duplicate")
+		err = createFile(v, "/stage2/new", 0655, time2, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /stage2/new")
+		fatalfIfErr(t, v.Close(), "failed to close stage 2")
+
+		//------------ Stage 3
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load for stage 3")
+		expected := []fileinfoTest{
+			{"/", testMod, ignoreTime, "", "directory/directory", "", emptyTags},
+			{"/stage1", 0655, time1, helloWorldSha512, "text/plain; charset=utf-8", "", emptyTags},
+			{"/stage2", 0655 | fs.ModeDir, time2, "", "directory/directory", "", emptyTags},
+			{"/stage2/duplicate", 0655, time2, helloWorldSha512, "text/plain; charset=utf-8", "", emptyTags},
+			{"/stage2/new", 0655, time2, helloFooSha512, "text/plain; charset=utf-8", "", emptyTags},
+		}
+		assertFiles(t, expected, v, "after stage 3")
+		stage1, err := v.Stat("/stage1")
+		fatalfIfErr(t, err, "failed to stat /stage1")
+		duplicate, err := v.Stat("/stage2/duplicate")
+		fatalfIfErr(t, err, "failed to stat /stage2/duplicate")
+		assertEqual(t, stage1.ID(), duplicate.ID(), "duplicate should use the file from stage 1")
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/checkpointed", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /checkpointed")
+		fatalfIfErr(t, v.Checkpoint(), "failed to checkpoint")
+
+		child, err := v.Stat("/checkpointed")
+		fatalfIfErr(t, err, "failed to stat /checkpointed")
+		assertErr(t, ErrChild, child.Checkpoint(), "should only checkpoint the root")
+
+		//------------ Read the checkpoint while still running
+		reader, err := NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load checkpoint")
+		assertContent(t, "Hello, World!", reader, "/checkpointed")
+
+		//------------ Crash after the checkpoint
+		err = createFile(v, "/crashed", 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /crashed")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load after crash")
+		assertContent(t, "Hello, World!", v, "/checkpointed")
+		_, err = v.Stat("/crashed")
+		assertErr(t, ErrNotFound, err, "crashed files shouldnt be in the checkpoint")
+
+		fatalfIfErr(t, v.Close(), "failed to close")
+		assertErr(t, ErrClosed, v.Checkpoint(), "should error checkpointing after close")
+	})
+}
+
+// writeDB writes lines (and a trailer) as fin.db
+func writeDB(t *testing.T, tmp string, lines ...string) {
+	t.Helper()
+	db := ""
+	for _, line := range lines {
+		db += line + "\n"
+	}
+	sum := sha256.Sum256([]byte(db))
+	trailer, err := json.Marshal(dbTrailer{Checksum: hex.EncodeToString(sum[:]), Count: len(lines)})
+	fatalfIfErr(t, err, "failed to marshal trailer")
+	err = os.WriteFile(filepath.Join(tmp, "fin.db"), []byte(db+string(trailer)+"\n"), 0644)
+	fatalfIfErr(t, err, "failed to write db")
+}
+
+func TestDBHeader(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp, WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to load")
+		header := v.DBHeader()
+		assertEqual(t, dbFormat, header.Format, "should be the current format")
+		assert(t, header.Storage.Compression, "should record compression")
+		assert(t, !header.Storage.Encryption, "should record no encryption")
+		assertEqual(t, 4, len(header.Hashes), "should record the hashes")
+		fatalfIfErr(t, v.Close(), "failed to close again")
+		v, err = NewFsFromDb(tmp, WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to load again")
+		assert(t, header.Created.Equal(v.DBHeader().Created), "created should be kept")
+
+		//------------ Newer formats are refused
+		root, err := json.Marshal(toJsonFs("/", false, v))
+		fatalfIfErr(t, err, "failed to marshal root")
+		writeDB(t, tmp, fmt.Sprintf(`{"format":%v,"library":"v9.0.0"}`, dbFormat+1), string(root))
+		_, err = NewFsFromDb(tmp)
+		assertErr(t, ErrUnsupportedFormat, err, "should refuse newer formats")
+		assert(t, strings.Contains(err.Error(), "v9.0.0"), "should say what wrote it: %v", err)
+
+		//------------ Entries are migrated from older formats
+		migrations := dbMigrations
+		defer func() { dbMigrations = migrations }()
+		dbMigrations = slices.Clone(migrations)
+		dbMigrations[0] = func(line []byte) ([]byte, error) {
+			return []byte(strings.Replace(string(line), `"name":"old"`, `"name":"new"`, 1)), nil
+		}
+		writeDB(t, tmp, `{"path":"/","name":"old","mode":2147484141}`)
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load format 1")
+		assertEqual(t, "new", v.Name(), "should migrate the entries")
+	})
+}
+
+func TestLoadCorruptDb(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/first", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /first")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		err = createFile(v, "/second", 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /second")
+		fatalfIfErr(t, v.Close(), "failed to close again")
+		_, err = os.Stat(filepath.Join(tmp, "fin.db.bak"))
+		fatalfIfErr(t, err, "previous db should be kept")
+
+		//------------ Db without a header and trailer (saved before they were added) still loads
+		db, err := os.ReadFile(filepath.Join(tmp, "fin.db"))
+		fatalfIfErr(t, err, "failed to read db")
+		lines := strings.SplitAfter(strings.TrimSuffix(string(db), "\n"), "\n")
+		legacy := treeLines(t, v) + "\n"
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, "fin.db"), []byte(legacy), 0644), "failed to write legacy db")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load db without trailer")
+		_, err = v.Stat("/second")
+		fatalfIfErr(t, err, "should load /second from db without trailer")
+		assertEqual(t, 1, v.DBHeader().Format, "db without a header is format 1")
+
+		//------------ Db with a header needs a trailer
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, "fin.db"), []byte(strings.Join(lines[:len(lines)-1], "")), 0644), "failed to write db without trailer")
+		err = (&Fs{db: v.db}).loadFrom("fin.db")
+		assertErr(t, ErrCorruptDB, err, "should error if a db with a header doesnt have a trailer")
+
+		//------------ Truncated db falls back to the previous one
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, "fin.db"), db[:len(db)-10], 0644), "failed to truncate db")
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load previous db")
+		assertErr(t, ErrInFilesystem, v.FsWarning(), "should warn that the previous db was loaded")
+		assertContent(t, "Hello, World!", v, "/first")
+		_, err = v.Stat("/second")
+		assertErr(t, ErrNotFound, err, "previous db shouldnt have /second")
+
+		//------------ Both corrupt
+		fatalfIfErr(t, os.WriteFile(filepath.Join(tmp, "fin.db.bak"), db[:len(db)-10], 0644), "failed to truncate backup")
+		_, err = NewFsFromDb(tmp)
+		assertErr(t, ErrCorruptDB, err, "should error if both are corrupt")
+	})
+}
+
+func TestReferenceIdentity(t *testing.T) {
+	for name, opts := range map[string][]Option{"json": nil, "compact": {WithCompactDB()}, "sqlite": {WithSQLite()}} {
+		tmpDir(t, func(tmp string) {
+			v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, opts...)
+			fatalfIfErr(t, err, "%v: failed to create virtual function", name)
+			err = createFile(v, "/dir/file", 0644, time1, "Hello, World!")
+			fatalfIfErr(t, err, "%v: failed to create /dir/file", name)
+			_, err = v.Hardlink("/dir", "/link/dir", 0755, time2)
+			fatalfIfErr(t, err, "%v: failed to hardlink dir", name)
+			_, err = v.Symlink("/dir/file", "/symlink", 0777, time2)
+			fatalfIfErr(t, err, "%v: failed to create symlink", name)
+			_, err = v.Hardlink("/symlink", "/link/symlink", 0777, time3)
+			fatalfIfErr(t, err, "%v: failed to hardlink symlink", name)
+			dir, err := v.Stat("/link/dir")
+			fatalfIfErr(t, err, "%v: failed to stat /link/dir", name)
+			dir.TagS("shared", "yes")
+			dir.Error(fmt.Errorf("bad dir"))
+			expected := treeLines(t, v)
+			fatalfIfErr(t, v.Close(), "%v: failed to close", name)
+
+			v, err = NewFsFromDb(tmp, opts...)
+			fatalfIfErr(t, err, "%v: failed to load", name)
+			assertEqual(t, expected, treeLines(t, v), "%v: loaded tree should match", name)
+			for _, paths := range [][2]string{{"/dir", "/link/dir"}, {"/symlink", "/link/symlink"}} {
+				a, err := v.Stat(paths[0])
+				fatalfIfErr(t, err, "%v: failed to stat %v", name, paths[0])
+				b, err := v.Stat(paths[1])
+				fatalfIfErr(t, err, "%v: failed to stat %v", name, paths[1])
+				assert(t, a.ref == b.ref, "%v: %v and %v should share a reference", name, paths[0], paths[1])
+			}
+			dir, err = v.Stat("/dir")
+			fatalfIfErr(t, err, "%v: failed to stat /dir", name)
+			assertEqual(t, "yes", fmt.Sprint(mustTag(t, dir, "shared")), "%v: tags should be kept", name)
+			assertErr(t, ErrInFilesystem, v.FsError(), "%v: errors should be kept", name)
+
+			//------------ Changes to one location are seen from the other
+			err = createFile(v, "/link/dir/new", 0644, time1, "Hello, Foo!")
+			fatalfIfErr(t, err, "%v: failed to create /link/dir/new", name)
+			assertContent(t, "Hello, Foo!", v, "/dir/new")
+		})
+	}
+}
+
+func TestReferencesSavedOnce(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/dir/file", 0644, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create /dir/file")
+		_, err = v.Hardlink("/dir", "/link", 0755, time2)
+		fatalfIfErr(t, err, "failed to hardlink dir")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		db, err := os.ReadFile(filepath.Join(tmp, finDB))
+		fatalfIfErr(t, err, "failed to read db")
+		assertEqual(t, 1, strings.Count(string(db), `"name":"file"`), "children of a hardlinked dir should be saved once")
+		assertEqual(t, 1, strings.Count(string(db), helloWorldSha512), "references should be saved once")
+	})
+}
+
+func TestSectionSavedBeforeSource(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := newTestFolderFs(tmp)
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/z-archive", 0644, time1, "Hello, World!Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create /z-archive")
+		archive, err := v.Stat("/z-archive")
+		fatalfIfErr(t, err, "failed to stat /z-archive")
+		fatalfIfErr(t, createChildFile(archive, 0644, time1, "Hello, Bar!"), "failed to create layer of /z-archive")
+		member, err := v.Create("/a-member", 0644, time1)
+		fatalfIfErr(t, err, "failed to create /a-member")
+		fatalfIfErr(t, member.CreateSection(archive, 13, 11), "failed to create section")
+		expected := treeLines(t, v)
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		v, err = NewFsFromDb(tmp)
+		fatalfIfErr(t, err, "failed to load")
+		assertEqual(t, expected, treeLines(t, v), "layer of a source saved with a section first should load")
+		assertContent(t, "Hello, Foo!", v, "/a-member")
+	})
+}
@@ -0,0 +1,305 @@
+package virtualfs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonathongardner/fifo/buffer"
+)
+
+// File is the contents of a reference opened for reading
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// blobStore is where the contents of references (and fin.db) are kept,
+// everything is addressed by name (the reference id or "fin.db")
+type blobStore interface {
+	create(name string) (destination, error)
+	open(name string) (File, error)
+	remove(name string) error
+}
+
+// newStore wraps base with the stores needed for the options
+// NOTE: order matters, chunk first (so each chunk is compressed, etc), compress
+// before encrypting and defang last (whats on disk)
+func newStore(base blobStore, o *options) (blobStore, error) {
+	store := base
+	if len(o.defangKey) > 0 {
+		store = &defangStore{blobStore: store, key: o.defangKey}
+	}
+	if o.keyProvider != nil {
+		encStore, err := newEncryptStore(store, o.keyProvider)
+		if err != nil {
+			return nil, err
+		}
+		store = encStore
+	}
+	if o.compress {
+		store = &compressStore{blobStore: store, maxEntropy: o.maxEntropy}
+	}
+	if o.chunk {
+		store = newChunkStore(store)
+	}
+	return store, nil
+}
+
+// isDB returns true if name is a db (i.e. fin.db or its backup) instead of a stored file, dbs arent
+// compressed or chunked so the storage layout they have (see DBStorage) can be checked before its used
+func isDB(name string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(name, backupSuffix), ".db")
+}
+
+// ------------- dirStore ------------------
+// dirStore keeps everything as files in a directory on disk
+type dirStore struct {
+	dir string
+}
+
+func newDirStore(dir string) *dirStore {
+	return &dirStore{dir: dir}
+}
+
+func (ds *dirStore) path(name string) string {
+	return filepath.Join(ds.dir, name)
+}
+
+func (ds *dirStore) create(name string) (destination, error) {
+	// dbs (i.e. fin.db) are replaced atomically so a crash never leaves a partial db
+	if strings.HasSuffix(name, ".db") {
+		return newAtomicFile(ds.path(name))
+	}
+	return buffer.NewFileWriter(ds.path(name), bufferSize)
+}
+
+func (ds *dirStore) open(name string) (File, error) {
+	return os.Open(ds.path(name))
+}
+
+func (ds *dirStore) remove(name string) error {
+	return os.Remove(ds.path(name))
+}
+
+// ------------- dirStore ------------------
+
+// ------------- atomicFile ------------------
+// backupSuffix is the previous generation of a db kept by atomicFile
+const backupSuffix = ".bak"
+
+// atomicFile writes to a temp file that replaces path on Close (after an fsync)
+// the previous path is kept as path + backupSuffix
+type atomicFile struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+}
+
+func newAtomicFile(path string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{path: path, file: file, w: bufio.NewWriterSize(file, 64*1024)}, nil
+}
+
+func (af *atomicFile) Write(p []byte) (int, error) {
+	return af.w.Write(p)
+}
+
+func (af *atomicFile) Close() error {
+	if err := af.w.Flush(); err != nil {
+		af.Delete()
+		return err
+	}
+	if err := af.file.Sync(); err != nil {
+		af.Delete()
+		return err
+	}
+	if err := af.file.Close(); err != nil {
+		os.Remove(af.file.Name())
+		return err
+	}
+
+	// keep the previous generation, hardlink so there is never a time path doesnt exist
+	backup := af.path + backupSuffix
+	if err := os.Remove(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.Remove(af.file.Name())
+		return err
+	}
+	if err := os.Link(af.path, backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.Remove(af.file.Name())
+		return err
+	}
+	if err := os.Rename(af.file.Name(), af.path); err != nil {
+		os.Remove(af.file.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(af.path))
+}
+
+func (af *atomicFile) Delete() error {
+	af.file.Close()
+	return os.Remove(af.file.Name())
+}
+
+// syncDir fsyncs a dir so a rename in it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// some platforms (i.e. windows) cant sync a dir
+	if err := d.Sync(); err != nil && !errors.Is(err, fs.ErrInvalid) && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// ------------- atomicFile ------------------
+
+// ------------- sectionFile ------------------
+// sectionFile is a File for a section of something that can ReadAt
+type sectionFile struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// newSectionFile returns a File for length bytes of file starting at offset
+// a length < 0 means to the end of file
+func newSectionFile(file File, offset, length int64) (File, error) {
+	if length < 0 {
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		length = max(size-offset, 0)
+	}
+	return &sectionFile{SectionReader: io.NewSectionReader(file, offset, length), Closer: file}, nil
+}
+
+// newReaderAtFile returns a File for the first size bytes of ra, closing ra on close
+func newReaderAtFile(ra interface {
+	io.ReaderAt
+	io.Closer
+}, size int64) File {
+	return &sectionFile{SectionReader: io.NewSectionReader(ra, 0, size), Closer: ra}
+}
+
+// ------------- sectionFile ------------------
+
+// ------------- options ------------------
+// Option configures how a virtual file system stores its data
+type Option func(*options)
+
+type options struct {
+	memoryLimit int64
+	spillDir    string
+	compress    bool
+	maxEntropy  float64
+	keyProvider KeyProvider
+	defangKey   []byte
+	chunk       bool
+	journal     int
+	sqlite      bool
+	compact     bool
+}
+
+var defaultMemoryLimit int64 = 128 * 1024 * 1024 // 128 * 1MB
+
+func newOptions(opts []Option) *options {
+	o := &options{memoryLimit: defaultMemoryLimit, maxEntropy: DefaultMaxEntropy}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMemoryLimit sets the max number of bytes an in memory fs can hold (see NewMemFs)
+// a limit <= 0 means no limit
+func WithMemoryLimit(limit int64) Option {
+	return func(o *options) {
+		o.memoryLimit = limit
+	}
+}
+
+// WithSpillDir sets a directory an in memory fs will write to once the memory limit is reached
+// instead of returning ErrMemoryLimit (see NewMemFs)
+func WithSpillDir(dir string) Option {
+	return func(o *options) {
+		o.spillDir = dir
+	}
+}
+
+// WithCompression zstd compresses files as they are stored, files are decompressed
+// when opened. Files whose start has an entropy (bits per byte) above maxEntropy
+// are stored uncompressed since they are most likely already compressed/encrypted.
+// See DefaultMaxEntropy
+func WithCompression(maxEntropy float64) Option {
+	return func(o *options) {
+		o.compress = true
+		o.maxEntropy = maxEntropy
+	}
+}
+
+// WithEncryption encrypts (AES-GCM) all the stored files and the db with the key from kp.
+// Loading with the wrong key returns ErrWrongKey
+func WithEncryption(kp KeyProvider) Option {
+	return func(o *options) {
+		o.keyProvider = kp
+	}
+}
+
+// WithDefang XORs stored files and the db with key so samples (i.e. malware) are
+// never stored as is and dont get quarantined by antivirus
+func WithDefang(key []byte) Option {
+	return func(o *options) {
+		o.defangKey = key
+	}
+}
+
+// WithChunking splits stored files into content defined chunks (stored by their sha256)
+// so files that are similar (i.e. disk images) share storage for the parts that are the same
+func WithChunking() Option {
+	return func(o *options) {
+		o.chunk = true
+	}
+}
+
+// WithJournal writes changes to an append only journal so NewFsFromDb can recover the tree if the process
+// dies before Close, its due for compaction every compactEvery records (<= 0 uses DefaultJournalCompaction)
+func WithJournal(compactEvery int) Option {
+	return func(o *options) {
+		if compactEvery <= 0 {
+			compactEvery = DefaultJournalCompaction
+		}
+		o.journal = compactEvery
+	}
+}
+
+// WithSQLite saves the tree to fin.sqlite instead of fin.db so it can be queried without loading
+// it (see OpenMetaDB). NewFsFromDb loads either. Needs a storage dir and cant be used with WithEncryption
+func WithSQLite() Option {
+	return func(o *options) {
+		o.sqlite = true
+	}
+}
+
+// WithCompactDB saves fin.db in a compact binary encoding (cbor) thats smaller and faster to load than
+// json lines. NewFsFromDb loads either so loading with (or without) it changes the encoding at Close
+func WithCompactDB() Option {
+	return func(o *options) {
+		o.compact = true
+	}
+}
+
+// ------------- options ------------------
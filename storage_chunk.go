@@ -0,0 +1,435 @@
+package virtualfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var chunkedMagic = []byte("VFSC")
+var wholeMagic = []byte("VFSW")
+
+// FastCDC sizes, files smaller than the max chunk size are stored whole
+const chunkMinSize = 16 * 1024  // 16KB
+const chunkAvgSize = 64 * 1024  // 64KB
+const chunkMaxSize = 256 * 1024 // 256KB
+
+// manifest entries are the chunk sha256 and its size
+const chunkEntrySize = sha256.Size + 4
+
+// normalized chunking, harder to cut before the average and easier after
+const chunkMaskS uint64 = (1<<18 - 1) << (64 - 18)
+const chunkMaskL uint64 = (1<<14 - 1) << (64 - 14)
+
+// gear is the random table used by the rolling hash, it is generated from a
+// fixed seed (splitmix64) so chunk boundaries never change between versions
+var gear = func() [256]uint64 {
+	table := [256]uint64{}
+	seed := uint64(0x7669727475616c66) // "virtualf"
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunkBoundary returns where the first chunk of data ends
+func chunkBoundary(data []byte) int {
+	n := len(data)
+	if n <= chunkMinSize {
+		return n
+	}
+	n = min(n, chunkMaxSize)
+	normal := min(n, chunkAvgSize)
+
+	fp := uint64(0)
+	i := chunkMinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&chunkMaskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&chunkMaskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+func chunkName(sum []byte) string {
+	return "chunk-" + hex.EncodeToString(sum)
+}
+
+// ------------- chunkStore ------------------
+// chunkStore splits files into content defined chunks (FastCDC) stored by their sha256
+// so similar files share storage. The file itself is a manifest of its chunks.
+// Chunks are counted for each manifest that uses them (the manifests already stored are
+// counted when loaded, see track) and removed once no manifest does
+type chunkStore struct {
+	blobStore
+	mu   sync.Mutex
+	uses map[string]int
+}
+
+func newChunkStore(store blobStore) *chunkStore {
+	return &chunkStore{blobStore: store, uses: make(map[string]int)}
+}
+
+// writeChunk stores the chunk (with the sha256 sum) if it doesnt exist yet and returns its name
+func (cs *chunkStore) writeChunk(sum []byte, data []byte) (string, error) {
+	name := chunkName(sum)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.uses[name] > 0 {
+		cs.uses[name]++
+		return name, nil
+	}
+	// left by a manifest that isnt stored anymore (i.e. the process died before it was written)
+	if file, err := cs.blobStore.open(name); err == nil {
+		file.Close()
+		cs.uses[name] = 1
+		return name, nil
+	}
+
+	file, err := cs.blobStore.create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Delete()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	cs.uses[name] = 1
+	return name, nil
+}
+
+// track counts the chunks used by the stored file name (stored before the store was opened)
+// so they are removed once nothing uses them (see release)
+func (cs *chunkStore) track(name string) error {
+	file, err := cs.blobStore.open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	chunked, err := isChunked(file, name)
+	if err != nil || !chunked {
+		return err
+	}
+	names, _, err := readManifest(file)
+	if err != nil {
+		return fmt.Errorf("error reading manifest %v - %w", name, err)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, chunk := range names {
+		cs.uses[chunk]++
+	}
+	return nil
+}
+
+// release removes the chunks if nothing else uses them, chunks that arent counted
+// (see track) are kept since we dont know what uses them
+func (cs *chunkStore) release(names []string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, name := range names {
+		count, ok := cs.uses[name]
+		if !ok {
+			continue
+		}
+		if count > 1 {
+			cs.uses[name] = count - 1
+			continue
+		}
+		delete(cs.uses, name)
+		if err := cs.blobStore.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackChunks counts the chunks used by the stored files named (see chunkStore.track)
+// if the store is chunked, files that arent stored are skipped
+func trackChunks(store blobStore, names []string) error {
+	cs, ok := store.(*chunkStore)
+	if !ok {
+		return nil
+	}
+	for _, name := range names {
+		if err := cs.track(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *chunkStore) create(name string) (destination, error) {
+	// dbs are only ever read whole so dont bother
+	if isDB(name) {
+		return cs.blobStore.create(name)
+	}
+	return &chunkWriter{store: cs, name: name}, nil
+}
+
+func (cs *chunkStore) open(name string) (File, error) {
+	file, err := cs.blobStore.open(name)
+	if err != nil || isDB(name) {
+		return file, err
+	}
+
+	chunked, err := isChunked(file, name)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !chunked {
+		return newSectionFile(file, magicSize, -1)
+	}
+	defer file.Close()
+	names, sizes, err := readManifest(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %v - %w", name, err)
+	}
+	return newChunkFile(cs, names, sizes), nil
+}
+
+func (cs *chunkStore) remove(name string) error {
+	if isDB(name) {
+		return cs.blobStore.remove(name)
+	}
+	file, err := cs.blobStore.open(name)
+	if err != nil {
+		return err
+	}
+	var names []string
+	chunked, err := isChunked(file, name)
+	if err == nil && chunked {
+		names, _, err = readManifest(file)
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("error reading manifest %v - %w", name, err)
+	}
+
+	if err := cs.blobStore.remove(name); err != nil {
+		return err
+	}
+	return cs.release(names)
+}
+
+// isChunked returns true if the stored file is a manifest (see chunkedMagic) and false if its stored whole,
+// the db says if the store is chunked (see checkStorage) so a file without either isnt from this store
+func isChunked(file File, name string) (bool, error) {
+	magic := make([]byte, magicSize)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("error reading magic %v - %w", name, err)
+	}
+	switch {
+	case bytes.Equal(magic[:n], chunkedMagic):
+		return true, nil
+	case bytes.Equal(magic[:n], wholeMagic):
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %v isnt chunked", ErrStorageMismatch, name)
+	}
+}
+
+// readManifest returns the chunk names and sizes from a manifest
+func readManifest(file File) ([]string, []int64, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := make([]byte, size-magicSize)
+	if _, err := file.ReadAt(manifest, magicSize); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	if len(manifest)%chunkEntrySize != 0 {
+		return nil, nil, fmt.Errorf("manifest is corrupt")
+	}
+
+	names := make([]string, 0, len(manifest)/chunkEntrySize)
+	sizes := make([]int64, 0, len(manifest)/chunkEntrySize)
+	for entry := range slices.Chunk(manifest, chunkEntrySize) {
+		names = append(names, chunkName(entry[:sha256.Size]))
+		sizes = append(sizes, int64(binary.BigEndian.Uint32(entry[sha256.Size:])))
+	}
+	return names, sizes, nil
+}
+
+// ------------- chunkStore ------------------
+
+// ------------- chunkWriter ------------------
+type chunkWriter struct {
+	store    *chunkStore
+	name     string
+	buf      []byte
+	names    []string
+	manifest []byte
+	// deleted (i.e. a duplicate) so Close doesnt write anything
+	deleted bool
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	// only cut once there is a full max chunk so boundaries dont depend on write sizes
+	for len(cw.buf) >= chunkMaxSize {
+		if err := cw.cut(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *chunkWriter) cut() error {
+	size := chunkBoundary(cw.buf)
+	data := cw.buf[:size]
+	sum := sha256.Sum256(data)
+	name, err := cw.store.writeChunk(sum[:], data)
+	if err != nil {
+		return err
+	}
+
+	cw.manifest = append(cw.manifest, sum[:]...)
+	cw.manifest = binary.BigEndian.AppendUint32(cw.manifest, uint32(size))
+	cw.names = append(cw.names, name)
+	cw.buf = cw.buf[size:]
+	return nil
+}
+
+func (cw *chunkWriter) Close() error {
+	if cw.deleted {
+		return nil
+	}
+	file, err := cw.store.blobStore.create(cw.name)
+	if err != nil {
+		cw.Delete()
+		return err
+	}
+
+	// small files arent worth chunking
+	toWrite := slices.Concat(wholeMagic, cw.buf)
+	if len(cw.names) > 0 {
+		for len(cw.buf) > 0 {
+			if err := cw.cut(); err != nil {
+				file.Delete()
+				cw.Delete()
+				return err
+			}
+		}
+		toWrite = slices.Concat(chunkedMagic, cw.manifest)
+	}
+
+	if _, err := file.Write(toWrite); err != nil {
+		file.Delete()
+		cw.Delete()
+		return err
+	}
+	cw.buf = nil
+	return file.Close()
+}
+
+func (cw *chunkWriter) Delete() error {
+	cw.deleted = true
+	cw.buf = nil
+	names := cw.names
+	cw.names = nil
+	return cw.store.release(names)
+}
+
+// ------------- chunkWriter ------------------
+
+// ------------- chunkFile ------------------
+// chunkFile reads from the chunks in a manifest keeping the last chunk open
+type chunkFile struct {
+	store   *chunkStore
+	names   []string
+	offsets []int64
+	mu      sync.Mutex
+	current int
+	file    File
+}
+
+func newChunkFile(store *chunkStore, names []string, sizes []int64) File {
+	offsets := make([]int64, len(sizes)+1)
+	for i, size := range sizes {
+		offsets[i+1] = offsets[i] + size
+	}
+	cf := &chunkFile{store: store, names: names, offsets: offsets, current: -1}
+	return newReaderAtFile(cf, offsets[len(sizes)])
+}
+
+func (cf *chunkFile) chunk(i int) (File, error) {
+	if cf.current == i {
+		return cf.file, nil
+	}
+	if cf.file != nil {
+		cf.file.Close()
+		cf.file, cf.current = nil, -1
+	}
+	file, err := cf.store.blobStore.open(cf.names[i])
+	if err != nil {
+		return nil, err
+	}
+	cf.file, cf.current = file, i
+	return file, nil
+}
+
+func (cf *chunkFile) ReadAt(p []byte, off int64) (int, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	read := 0
+	for read < len(p) {
+		i := sort.Search(len(cf.names), func(i int) bool { return cf.offsets[i+1] > off })
+		if i == len(cf.names) {
+			return read, io.EOF
+		}
+		file, err := cf.chunk(i)
+		if err != nil {
+			return read, err
+		}
+
+		want := min(int64(len(p)-read), cf.offsets[i+1]-off)
+		n, err := file.ReadAt(p[read:read+int(want)], off-cf.offsets[i])
+		read += n
+		off += int64(n)
+		if err != nil && !(errors.Is(err, io.EOF) && int64(n) == want) {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func (cf *chunkFile) Close() error {
+	if cf.file != nil {
+		return cf.file.Close()
+	}
+	return nil
+}
+
+// ------------- chunkFile ------------------
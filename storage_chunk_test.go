@@ -0,0 +1,116 @@
+package virtualfs
+
+import (
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func countChunks(t *testing.T, tmp string) int {
+	t.Helper()
+
+	d, err := os.ReadDir(tmp)
+	fatalfIfErr(t, err, "failed to read dir")
+	count := 0
+	for _, e := range d {
+		if strings.HasPrefix(e.Name(), "chunk-") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestChunking(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithChunking())
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		image := make([]byte, 4*1024*1024)
+		rand.New(rand.NewSource(47)).Read(image)
+		err = createFile(v, "/image1", 0655, time1, string(image))
+		fatalfIfErr(t, err, "failed to create virtual file /image1")
+		chunks := countChunks(t, tmp)
+		assert(t, chunks > 4*1024*1024/chunkMaxSize, "expected image to be chunked %v", chunks)
+
+		// change one byte
+		image[2*1024*1024] ^= 0xff
+		err = createFile(v, "/image2", 0655, time1, string(image))
+		fatalfIfErr(t, err, "failed to create virtual file /image2")
+		newChunks := countChunks(t, tmp) - chunks
+		assert(t, newChunks > 0 && newChunks <= 2, "expected only the changed chunk to be added %v", newChunks)
+
+		// duplicate file shouldnt remove the chunks it shares
+		err = createFile(v, "/image3", 0655, time1, string(image))
+		fatalfIfErr(t, err, "failed to create virtual file /image3")
+		assertEqual(t, chunks+newChunks, countChunks(t, tmp), "duplicate shouldnt change chunks")
+
+		// small files are stored whole
+		err = createFile(v, "/hello", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /hello")
+		assertEqual(t, chunks+newChunks, countChunks(t, tmp), "small file shouldnt be chunked")
+
+		assertContent(t, string(image), v, "/image2")
+		assertContent(t, "Hello, World!", v, "/hello")
+
+		file, err := v.OpenReader("/image2")
+		fatalfIfErr(t, err, "failed to open /image2")
+		defer file.Close()
+		p := make([]byte, 1024*1024)
+		_, err = file.ReadAt(p, 1536*1024)
+		fatalfIfErr(t, err, "failed to read at across chunks")
+		assertEqual(t, string(image[1536*1024:2560*1024]), string(p), "read at doesnt match")
+
+		//------------ Close and reload
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp, WithChunking(), WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to load chunked fs")
+		assertContent(t, string(image), newV, "/image3")
+	})
+}
+
+func TestChunkBoundaryIsContentDefined(t *testing.T) {
+	data := make([]byte, chunkMaxSize)
+	rand.New(rand.NewSource(53)).Read(data)
+
+	cut := chunkBoundary(data)
+	assert(t, cut >= chunkMinSize && cut <= chunkMaxSize, "cut should be between min and max %v", cut)
+
+	// adding data before a boundary (after the min size) shouldnt change the next boundary
+	shifted := append(append([]byte{}, data[:cut]...), data...)
+	assertEqual(t, cut, chunkBoundary(shifted), "first boundary should be the same")
+	assertEqual(t, cut, chunkBoundary(shifted[cut:]), "next boundary should be the same")
+}
+
+func TestChunkingDuplicate(t *testing.T) {
+	assertDuplicateStoredOnce(t, WithChunking())
+}
+
+func TestChunkingRemoveAfterReload(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithChunking())
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		image := make([]byte, 4*1024*1024)
+		rand.New(rand.NewSource(59)).Read(image)
+		err = createFile(v, "/image1", 0655, time1, string(image))
+		fatalfIfErr(t, err, "failed to create virtual file /image1")
+		chunks := countChunks(t, tmp)
+		image[2*1024*1024] ^= 0xff
+		err = createFile(v, "/image2", 0655, time1, string(image))
+		fatalfIfErr(t, err, "failed to create virtual file /image2")
+		fatalfIfErr(t, v.Close(), "failed to close")
+
+		//------------ Reloading without chunking would read the manifests as the files
+		_, err = NewFsFromDb(tmp)
+		assertErr(t, ErrStorageMismatch, err, "should error if reloaded without chunking")
+
+		//------------ Reload, chunks only /image2 uses are removed
+		v, err = NewFsFromDb(tmp, WithChunking())
+		fatalfIfErr(t, err, "failed to load chunked fs")
+		fatalfIfErr(t, v.Remove("/image2"), "failed to remove /image2")
+		assertEqual(t, chunks, countChunks(t, tmp), "expected chunks only /image2 used to be removed")
+		fatalfIfErr(t, v.Remove("/image1"), "failed to remove /image1")
+		assertEqual(t, 0, countChunks(t, tmp), "expected all chunks to be removed")
+	})
+}
@@ -0,0 +1,315 @@
+package virtualfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var compressedMagic = []byte("VFSZ")
+var uncompressedMagic = []byte("VFSR")
+
+const magicSize = 4
+const entropySampleSize = 64 * 1024 // 64KB
+
+// DefaultMaxEntropy is a good max entropy for WithCompression
+var DefaultMaxEntropy = 7.5
+
+// ------------- compressStore ------------------
+// compressStore zstd compresses everything written to the store it wraps. Since the
+// identifiers writer is in front of the store, hashes are of the uncompressed content.
+// Each blob starts with a magic so we know if it was compressed, the db says if the
+// store is compressed (see checkStorage) so a blob without one isnt from this store
+type compressStore struct {
+	blobStore
+	maxEntropy float64
+}
+
+func (cs *compressStore) create(name string) (destination, error) {
+	file, err := cs.blobStore.create(name)
+	if err != nil || isDB(name) {
+		return file, err
+	}
+	return &compressWriter{file: file, maxEntropy: cs.maxEntropy}, nil
+}
+
+func (cs *compressStore) open(name string) (File, error) {
+	file, err := cs.blobStore.open(name)
+	if err != nil || isDB(name) {
+		return file, err
+	}
+
+	magic := make([]byte, magicSize)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && err != io.EOF { //nolint:errorlint
+		file.Close()
+		return nil, fmt.Errorf("error reading magic %v - %w", name, err)
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.Equal(magic, compressedMagic):
+		return newZstdFile(file)
+	case bytes.Equal(magic, uncompressedMagic):
+		return newSectionFile(file, magicSize, -1)
+	default:
+		file.Close()
+		return nil, fmt.Errorf("%w: %v isnt compressed", ErrStorageMismatch, name)
+	}
+}
+
+// ------------- compressStore ------------------
+
+// ------------- compressWriter ------------------
+// compressWriter samples the start of the file to decide if it is worth compressing
+type compressWriter struct {
+	file       destination
+	maxEntropy float64
+	sample     []byte
+	decided    bool
+	zw         *zstd.Encoder
+	// deleted (i.e. a duplicate) so Close doesnt write anything
+	deleted bool
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.write(p)
+	}
+
+	cw.sample = append(cw.sample, p...)
+	if len(cw.sample) >= entropySampleSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	return cw.file.Write(p)
+}
+
+// decide writes the magic and sample, compressing if the sample entropy is low enough
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+
+	magic := uncompressedMagic
+	if entropy(cw.sample) <= cw.maxEntropy {
+		magic = compressedMagic
+	}
+	if _, err := cw.file.Write(magic); err != nil {
+		return err
+	}
+
+	if bytes.Equal(magic, compressedMagic) {
+		zw, err := zstd.NewWriter(cw.file, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("error creating zstd writer %w", err)
+		}
+		cw.zw = zw
+	}
+
+	_, err := cw.write(cw.sample)
+	cw.sample = nil
+	return err
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.deleted {
+		return nil
+	}
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Close(); err != nil {
+			return fmt.Errorf("error closing zstd writer %w", err)
+		}
+	}
+	return cw.file.Close()
+}
+
+func (cw *compressWriter) Delete() error {
+	cw.deleted = true
+	if cw.zw != nil {
+		cw.zw.Close()
+	}
+	return cw.file.Delete()
+}
+
+// ------------- compressWriter ------------------
+
+// ------------- zstdFile ------------------
+// zstdFile makes a zstd stream seekable by decompressing from the start
+// when it needs to go backwards. Read/Seek and ReadAt each have their own
+// cursor so ReadAt doesnt change the offset used by Read
+type zstdFile struct {
+	file   File
+	cursor *zstdCursor
+	at     *zstdCursor
+	offset int64
+	size   int64
+	mu     sync.Mutex
+}
+
+func newZstdFile(file File) (*zstdFile, error) {
+	return &zstdFile{
+		file:   file,
+		cursor: &zstdCursor{file: file},
+		at:     &zstdCursor{file: file},
+		size:   -1,
+	}, nil
+}
+
+func (zf *zstdFile) Read(p []byte) (int, error) {
+	n, err := zf.cursor.readAt(p, zf.offset)
+	zf.offset += int64(n)
+	return n, err
+}
+
+func (zf *zstdFile) ReadAt(p []byte, off int64) (int, error) {
+	zf.mu.Lock()
+	defer zf.mu.Unlock()
+
+	n, err := zf.at.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (zf *zstdFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += zf.offset
+	case io.SeekEnd:
+		size, err := zf.Size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %v", offset)
+	}
+	zf.offset = offset
+	return offset, nil
+}
+
+// Size returns the uncompressed size (decompressing the whole file the first time)
+func (zf *zstdFile) Size() (int64, error) {
+	zf.mu.Lock()
+	defer zf.mu.Unlock()
+
+	if zf.size >= 0 {
+		return zf.size, nil
+	}
+	dec, err := newZstdDecoder(zf.file)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.Close()
+
+	size, err := io.Copy(io.Discard, dec)
+	if err != nil {
+		return 0, err
+	}
+	zf.size = size
+	return size, nil
+}
+
+func (zf *zstdFile) Close() error {
+	zf.cursor.close()
+	zf.at.close()
+	return zf.file.Close()
+}
+
+// zstdCursor is a decoder and its position in the uncompressed stream
+type zstdCursor struct {
+	file File
+	dec  *zstd.Decoder
+	pos  int64
+}
+
+func (zc *zstdCursor) readAt(p []byte, off int64) (int, error) {
+	if zc.dec == nil || off < zc.pos {
+		zc.close()
+		dec, err := newZstdDecoder(zc.file)
+		if err != nil {
+			return 0, err
+		}
+		zc.dec = dec
+		zc.pos = 0
+	}
+
+	if off > zc.pos {
+		skipped, err := io.CopyN(io.Discard, zc.dec, off-zc.pos)
+		zc.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(zc.dec, p)
+	zc.pos += int64(n)
+	if err == io.ErrUnexpectedEOF { //nolint:errorlint
+		err = io.EOF
+	}
+	if n > 0 && err == io.EOF { //nolint:errorlint
+		err = nil
+	}
+	return n, err
+}
+
+func (zc *zstdCursor) close() {
+	if zc.dec != nil {
+		zc.dec.Close()
+		zc.dec = nil
+	}
+}
+
+func newZstdDecoder(file File) (*zstd.Decoder, error) {
+	dec, err := zstd.NewReader(io.NewSectionReader(file, magicSize, math.MaxInt64-magicSize), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd reader %w", err)
+	}
+	return dec, nil
+}
+
+// ------------- zstdFile ------------------
+
+// entropy returns the shannon entropy (bits per byte) of b
+func entropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	counts := [256]int{}
+	for _, c := range b {
+		counts[c]++
+	}
+
+	total := float64(len(b))
+	toReturn := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / total
+		toReturn -= p * math.Log2(p)
+	}
+	return toReturn
+}
@@ -0,0 +1,100 @@
+package virtualfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		content := strings.Repeat("Hello, World! ", 1000)
+		err = createFile(v, "/text", 0655, time1, content)
+		fatalfIfErr(t, err, "failed to create virtual file /text")
+
+		random := make([]byte, 1000)
+		rand.Read(random)
+		err = createFile(v, "/random", 0655, time1, string(random))
+		fatalfIfErr(t, err, "failed to create virtual file /random")
+
+		err = createFile(v, "/hello", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /hello")
+
+		text, err := v.Stat("/text")
+		fatalfIfErr(t, err, "failed to stat /text")
+		assertEqual(t, int64(len(content)), text.Size(), "size should be uncompressed size")
+
+		raw, err := os.ReadFile(text.FilePath())
+		fatalfIfErr(t, err, "failed to read stored /text")
+		assert(t, bytes.HasPrefix(raw, compressedMagic), "expected /text to be compressed")
+		assert(t, len(raw) < len(content)/10, "expected /text to be smaller when compressed %v", len(raw))
+
+		randomFs, err := v.Stat("/random")
+		fatalfIfErr(t, err, "failed to stat /random")
+		raw, err = os.ReadFile(randomFs.FilePath())
+		fatalfIfErr(t, err, "failed to read stored /random")
+		assert(t, bytes.HasPrefix(raw, uncompressedMagic), "expected /random to not be compressed")
+
+		hello, err := v.Stat("/hello")
+		fatalfIfErr(t, err, "failed to stat /hello")
+		assertEqual(t, helloWorldSha512, hello.Sha512(), "hash should be of uncompressed content")
+
+		assertContent(t, content, v, "/text")
+		assertContent(t, string(random), v, "/random")
+		assertContent(t, "Hello, World!", v, "/hello")
+
+		//------------ Close and reload
+		fatalfIfErr(t, v.Close(), "failed to close")
+		_, err = NewFsFromDb(tmp)
+		assertErr(t, ErrStorageMismatch, err, "should error if reloaded without compression")
+		newV, err := NewFsFromDb(tmp, WithCompression(DefaultMaxEntropy))
+		fatalfIfErr(t, err, "failed to load compressed fs")
+		assertContent(t, content, newV, "/text")
+		assertContent(t, "Hello, World!", newV, "/hello")
+	})
+}
+
+func TestCompressionSeek(t *testing.T) {
+	v, err := NewMemFs("foo-folder", testMod, testTime, nil, WithCompression(DefaultMaxEntropy))
+	fatalfIfErr(t, err, "failed to create virtual function")
+
+	content := strings.Repeat("0123456789", 10000)
+	err = createFile(v, "/text", 0655, time1, content)
+	fatalfIfErr(t, err, "failed to create virtual file /text")
+
+	file, err := v.OpenReader("/text")
+	fatalfIfErr(t, err, "failed to open /text")
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	fatalfIfErr(t, err, "failed to seek to end")
+	assertEqual(t, int64(len(content)), size, "seek end should return uncompressed size")
+
+	_, err = file.Seek(50005, io.SeekStart)
+	fatalfIfErr(t, err, "failed to seek")
+	p := make([]byte, 5)
+	_, err = io.ReadFull(file, p)
+	fatalfIfErr(t, err, "failed to read after seek")
+	assertEqual(t, "56789", string(p), "content after seek doesnt match")
+
+	_, err = file.ReadAt(p, 12)
+	fatalfIfErr(t, err, "failed to read at")
+	assertEqual(t, "23456", string(p), "content read at doesnt match")
+
+	_, err = io.ReadFull(file, p)
+	fatalfIfErr(t, err, "failed to read after read at")
+	assertEqual(t, "01234", string(p), "read at shouldnt change offset")
+
+	_, err = file.ReadAt(p, int64(len(content)-2))
+	assertErr(t, io.EOF, err, "read at past the end should EOF")
+}
+
+func TestCompressionDuplicate(t *testing.T) {
+	assertDuplicateStoredOnce(t, WithCompression(DefaultMaxEntropy))
+}
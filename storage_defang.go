@@ -0,0 +1,115 @@
+package virtualfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var defangedMagic = []byte("VFSX")
+
+// ------------- defangStore ------------------
+// defangStore XORs everything written to the store it wraps so samples (i.e. malware)
+// are never on disk as is and wont get picked up (and deleted) by antivirus. Blobs
+// start with a magic, the db says if the store is defanged (see checkStorage) so a
+// blob without it isnt from this store
+type defangStore struct {
+	blobStore
+	key []byte
+}
+
+func (ds *defangStore) create(name string) (destination, error) {
+	file, err := ds.blobStore.create(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(defangedMagic); err != nil {
+		file.Delete()
+		return nil, err
+	}
+	return &defangWriter{file: file, key: ds.key}, nil
+}
+
+func (ds *defangStore) open(name string) (File, error) {
+	file, err := ds.blobStore.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, magicSize)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, fmt.Errorf("error reading magic %v - %w", name, err)
+	}
+	if !bytes.Equal(magic[:n], defangedMagic) {
+		file.Close()
+		return nil, fmt.Errorf("%w: %v isnt defanged", ErrStorageMismatch, name)
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newReaderAtFile(&defangFile{file: file, key: ds.key}, size-magicSize), nil
+}
+
+// ------------- defangStore ------------------
+
+// xor xors p with the key, as if p is at offset off of the file
+func xor(key, p []byte, off int64) {
+	for i := range p {
+		p[i] ^= key[(off+int64(i))%int64(len(key))]
+	}
+}
+
+// ------------- defangWriter ------------------
+type defangWriter struct {
+	file   destination
+	key    []byte
+	offset int64
+}
+
+func (dw *defangWriter) Write(p []byte) (int, error) {
+	// dont change p, its still the callers
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	xor(dw.key, buf, dw.offset)
+
+	n, err := dw.file.Write(buf)
+	dw.offset += int64(n)
+	return n, err
+}
+
+func (dw *defangWriter) Close() error {
+	return dw.file.Close()
+}
+
+func (dw *defangWriter) Delete() error {
+	return dw.file.Delete()
+}
+
+// ------------- defangWriter ------------------
+
+// ------------- defangFile ------------------
+type defangFile struct {
+	file File
+	key  []byte
+}
+
+func (df *defangFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %v", off)
+	}
+	n, err := df.file.ReadAt(p, off+magicSize)
+	xor(df.key, p[:n], off)
+	return n, err
+}
+
+func (df *defangFile) Close() error {
+	return df.file.Close()
+}
+
+// ------------- defangFile ------------------
@@ -0,0 +1,42 @@
+package virtualfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDefang(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		key := []byte("infected")
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithDefang(key))
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		sample := "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+		err = createFile(v, "/eicar", 0655, time1, sample)
+		fatalfIfErr(t, err, "failed to create virtual file /eicar")
+
+		eicar, err := v.Stat("/eicar")
+		fatalfIfErr(t, err, "failed to stat /eicar")
+		raw, err := os.ReadFile(eicar.FilePath())
+		fatalfIfErr(t, err, "failed to read stored /eicar")
+		assert(t, bytes.HasPrefix(raw, defangedMagic), "expected /eicar to be defanged")
+		assert(t, !bytes.Contains(raw, []byte("EICAR")), "stored file shouldnt have the sample as is")
+
+		assertContent(t, sample, v, "/eicar")
+
+		file, err := v.OpenReader("/eicar")
+		fatalfIfErr(t, err, "failed to open /eicar")
+		defer file.Close()
+		p := make([]byte, 5)
+		_, err = file.ReadAt(p, 33)
+		fatalfIfErr(t, err, "failed to read at")
+		assertEqual(t, sample[33:38], string(p), "read at doesnt match")
+
+		//------------ Close and reload
+		fatalfIfErr(t, v.Close(), "failed to close")
+		newV, err := NewFsFromDb(tmp, WithDefang(key))
+		fatalfIfErr(t, err, "failed to load defanged fs")
+		assertContent(t, sample, newV, "/eicar")
+	})
+}
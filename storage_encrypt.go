@@ -0,0 +1,292 @@
+package virtualfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ErrWrongKey = fmt.Errorf("wrong encryption key")
+var ErrDecrypt = fmt.Errorf("unable to decrypt")
+
+// KeyProvider provides the key used to encrypt the storage dir (see WithEncryption)
+type KeyProvider interface {
+	// Key returns an AES key (16, 24 or 32 bytes)
+	Key() ([]byte, error)
+}
+
+// StaticKey is a KeyProvider that always returns itself
+type StaticKey []byte
+
+func (sk StaticKey) Key() ([]byte, error) {
+	return sk, nil
+}
+
+var encryptedMagic = []byte("VFSE")
+
+const encryptChunkSize = 64 * 1024 // 64KB
+const keyCheckSize = 8
+const saltSize = 32
+
+// header is magic, key check, salt
+const encryptHeaderSize = magicSize + keyCheckSize + saltSize
+
+// ------------- encryptStore ------------------
+// encryptStore encrypts everything written to the store it wraps with AES-GCM using a key
+// derived (see blobAEAD) from a random salt per file so nonces are never reused across files.
+// Files are split into chunks that are sealed separately so they can be read at any offset.
+// Each chunk nonce is the chunk index and a flag for the last chunk so chunks cant be
+// reordered or the file truncated without failing to decrypt
+type encryptStore struct {
+	blobStore
+	key      []byte
+	keyCheck []byte
+}
+
+func newEncryptStore(store blobStore, kp KeyProvider) (*encryptStore, error) {
+	key, err := kp.Key()
+	if err != nil {
+		return nil, fmt.Errorf("error getting key - %w", err)
+	}
+	// fail early if the key isnt a valid AES key
+	if _, err := newGCM(key); err != nil {
+		return nil, err
+	}
+
+	keyCheck := sha256.Sum256(append([]byte("virtualfs key check"), key...))
+	return &encryptStore{blobStore: store, key: key, keyCheck: keyCheck[:keyCheckSize]}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher - %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcm - %w", err)
+	}
+	return aead, nil
+}
+
+// blobAEAD returns the aead of the key for a file derived from its salt (HMAC-SHA256 of the
+// key, truncated to the key size so its the same AES variant)
+func (es *encryptStore) blobAEAD(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, es.key)
+	mac.Write([]byte("virtualfs blob key"))
+	mac.Write(salt)
+	return newGCM(mac.Sum(nil)[:len(es.key)])
+}
+
+func (es *encryptStore) create(name string) (destination, error) {
+	file, err := es.blobStore.create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, encryptHeaderSize)
+	header = append(header, encryptedMagic...)
+	header = append(header, es.keyCheck...)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		file.Delete()
+		return nil, fmt.Errorf("error creating salt - %w", err)
+	}
+	header = append(header, salt...)
+	aead, err := es.blobAEAD(salt)
+	if err != nil {
+		file.Delete()
+		return nil, err
+	}
+
+	if _, err := file.Write(header); err != nil {
+		file.Delete()
+		return nil, err
+	}
+	return &encryptWriter{file: file, aead: aead}, nil
+}
+
+func (es *encryptStore) open(name string) (File, error) {
+	file, err := es.blobStore.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w %v: error reading header - %w", ErrDecrypt, name, err)
+	}
+	if !bytes.Equal(header[:magicSize], encryptedMagic) {
+		file.Close()
+		return nil, fmt.Errorf("%w %v: not encrypted", ErrDecrypt, name)
+	}
+	if !bytes.Equal(header[magicSize:magicSize+keyCheckSize], es.keyCheck) {
+		file.Close()
+		return nil, fmt.Errorf("%w: %v", ErrWrongKey, name)
+	}
+	aead, err := es.blobAEAD(header[magicSize+keyCheckSize:])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	encFile := &encryptFile{
+		file:  file,
+		name:  name,
+		aead:  aead,
+		chunk: -1,
+	}
+	encFile.setSize(size - encryptHeaderSize)
+	return newReaderAtFile(encFile, encFile.size), nil
+}
+
+// ------------- encryptStore ------------------
+
+// chunkNonce returns the nonce for chunk i, the key is only used for one file (see blobAEAD)
+// so the nonce only needs to be unique within it
+func chunkNonce(i int64, last bool) []byte {
+	nonce := make([]byte, 7, 12)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(i))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// ------------- encryptWriter ------------------
+// encryptWriter seals a chunk once it knows it isnt the last one
+type encryptWriter struct {
+	file  destination
+	aead  cipher.AEAD
+	buf   []byte
+	chunk int64
+	// deleted (i.e. a duplicate) so Close doesnt write anything
+	deleted bool
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	ew.buf = append(ew.buf, p...)
+	for len(ew.buf) > encryptChunkSize {
+		if err := ew.seal(ew.buf[:encryptChunkSize], false); err != nil {
+			return 0, err
+		}
+		ew.buf = ew.buf[encryptChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (ew *encryptWriter) seal(plain []byte, last bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.chunk, last), plain, nil)
+	ew.chunk++
+	_, err := ew.file.Write(sealed)
+	return err
+}
+
+func (ew *encryptWriter) Close() error {
+	if ew.deleted {
+		return nil
+	}
+	if err := ew.seal(ew.buf, true); err != nil {
+		return err
+	}
+	ew.buf = nil
+	return ew.file.Close()
+}
+
+func (ew *encryptWriter) Delete() error {
+	ew.deleted = true
+	ew.buf = nil
+	return ew.file.Delete()
+}
+
+// ------------- encryptWriter ------------------
+
+// ------------- encryptFile ------------------
+// encryptFile decrypts the chunks needed for each read, keeping the last one around
+type encryptFile struct {
+	file   File
+	name   string
+	aead   cipher.AEAD
+	chunks int64
+	size   int64
+	// last decrypted chunk
+	mu    sync.Mutex
+	chunk int64
+	plain []byte
+}
+
+// setSize works out the number of chunks and the plain size from the encrypted size
+func (ef *encryptFile) setSize(encSize int64) {
+	sealedSize := int64(encryptChunkSize + ef.aead.Overhead())
+	ef.chunks = (encSize + sealedSize - 1) / sealedSize
+	ef.size = max(encSize-ef.chunks*int64(ef.aead.Overhead()), 0)
+}
+
+func (ef *encryptFile) readChunk(i int64) ([]byte, error) {
+	if ef.chunk == i {
+		return ef.plain, nil
+	}
+
+	sealedSize := int64(encryptChunkSize + ef.aead.Overhead())
+	sealed := make([]byte, sealedSize)
+	n, err := ef.file.ReadAt(sealed, encryptHeaderSize+i*sealedSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	last := i == ef.chunks-1
+	plain, err := ef.aead.Open(nil, chunkNonce(i, last), sealed[:n], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w %v: chunk %v - %w", ErrDecrypt, ef.name, i, err)
+	}
+	ef.chunk, ef.plain = i, plain
+	return plain, nil
+}
+
+func (ef *encryptFile) ReadAt(p []byte, off int64) (int, error) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %v", off)
+	}
+	// sealed files always have at least one chunk (even if empty)
+	if ef.chunks == 0 {
+		return 0, fmt.Errorf("%w %v: missing chunks", ErrDecrypt, ef.name)
+	}
+
+	read := 0
+	for read < len(p) && off < ef.size {
+		plain, err := ef.readChunk(off / encryptChunkSize)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], plain[off%encryptChunkSize:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (ef *encryptFile) Close() error {
+	return ef.file.Close()
+}
+
+// ------------- encryptFile ------------------
@@ -0,0 +1,110 @@
+package virtualfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = StaticKey(bytes.Repeat([]byte{7}, 32))
+
+func TestEncryption(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithEncryption(testKey))
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		err = createFile(v, "/secret/hello", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /secret/hello")
+
+		hello, err := v.Stat("/secret/hello")
+		fatalfIfErr(t, err, "failed to stat /secret/hello")
+		assertEqual(t, helloWorldSha512, hello.Sha512(), "hash should be of plain content")
+		assertEqual(t, int64(13), hello.Size(), "size should be of plain content")
+
+		raw, err := os.ReadFile(hello.FilePath())
+		fatalfIfErr(t, err, "failed to read stored /secret/hello")
+		assert(t, !bytes.Contains(raw, []byte("Hello")), "stored file shouldnt have plain content")
+		assertContent(t, "Hello, World!", v, "/secret/hello")
+
+		//------------ Close and make sure db is encrypted
+		fatalfIfErr(t, v.Close(), "failed to close")
+		raw, err = os.ReadFile(filepath.Join(tmp, "fin.db"))
+		fatalfIfErr(t, err, "failed to read db")
+		assert(t, !bytes.Contains(raw, []byte("secret")), "db shouldnt have plain content")
+
+		//------------ Load with wrong key
+		_, err = NewFsFromDb(tmp, WithEncryption(StaticKey(bytes.Repeat([]byte{8}, 32))))
+		assertErr(t, ErrWrongKey, err, "should error loading with the wrong key")
+
+		//------------ Load with right key
+		newV, err := NewFsFromDb(tmp, WithEncryption(testKey))
+		fatalfIfErr(t, err, "failed to load with right key")
+		assertContent(t, "Hello, World!", newV, "/secret/hello")
+	})
+}
+
+func TestEncryptionChunks(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithEncryption(testKey), WithCompression(0))
+		fatalfIfErr(t, err, "failed to create virtual function")
+
+		content := strings.Repeat("0123456789", 3*encryptChunkSize/10+3)
+		err = createFile(v, "/big", 0655, time1, content)
+		fatalfIfErr(t, err, "failed to create virtual file /big")
+		assertContent(t, content, v, "/big")
+
+		file, err := v.OpenReader("/big")
+		fatalfIfErr(t, err, "failed to open /big")
+		defer file.Close()
+
+		p := make([]byte, 10)
+		_, err = file.ReadAt(p, encryptChunkSize-5)
+		fatalfIfErr(t, err, "failed to read across chunks")
+		assertEqual(t, content[encryptChunkSize-5:encryptChunkSize+5], string(p), "read across chunks doesnt match")
+
+		size, err := file.Seek(0, io.SeekEnd)
+		fatalfIfErr(t, err, "failed to seek end")
+		assertEqual(t, int64(len(content)), size, "size doesnt match")
+
+		//------------ Truncate to a chunk boundary
+		big, err := v.Stat("/big")
+		fatalfIfErr(t, err, "failed to stat /big")
+		err = os.Truncate(big.FilePath(), encryptHeaderSize+2*int64(encryptChunkSize+16))
+		fatalfIfErr(t, err, "failed to truncate /big")
+
+		truncated, err := v.OpenReader("/big")
+		fatalfIfErr(t, err, "failed to open /big")
+		defer truncated.Close()
+		_, err = io.ReadAll(truncated)
+		assertErr(t, ErrDecrypt, err, "should fail to decrypt truncated file")
+	})
+}
+
+func TestEncryptionDuplicate(t *testing.T) {
+	assertDuplicateStoredOnce(t, WithEncryption(testKey))
+}
+
+func TestEncryptionBlobKeys(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewFs(tmp, "foo-folder", testMod, testTime, nil, WithEncryption(testKey))
+		fatalfIfErr(t, err, "failed to create virtual function")
+		err = createFile(v, "/hello", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /hello")
+		err = createFile(v, "/foo", 0655, time1, "Hello, Foo!")
+		fatalfIfErr(t, err, "failed to create virtual file /foo")
+
+		salts := map[string]bool{}
+		for _, path := range []string{"/hello", "/foo"} {
+			n, err := v.Stat(path)
+			fatalfIfErr(t, err, "failed to stat %v", path)
+			raw, err := os.ReadFile(n.FilePath())
+			fatalfIfErr(t, err, "failed to read stored %v", path)
+			assert(t, bytes.HasPrefix(raw, encryptedMagic), "%v should be sealed with a blob key", path)
+			salts[string(raw[magicSize+keyCheckSize:encryptHeaderSize])] = true
+		}
+		assertEqual(t, 2, len(salts), "each file should have its own salt")
+	})
+}
@@ -0,0 +1,179 @@
+package virtualfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var ErrMemoryLimit = fmt.Errorf("in memory file system is over its memory limit")
+
+// ------------- memStore ------------------
+// memStore keeps everything in memory, once limit is reached it
+// will write new files to spill (if set) or error
+type memStore struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	blobs map[string][]byte
+	spill *dirStore
+}
+
+func newMemStore(limit int64, spillDir string) *memStore {
+	ms := &memStore{limit: limit, blobs: make(map[string][]byte)}
+	if spillDir != "" {
+		ms.spill = newDirStore(spillDir)
+	}
+	return ms
+}
+
+// reserve adds size to the used bytes, returns ErrMemoryLimit if it would go over the limit
+func (ms *memStore) reserve(size int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.limit > 0 && ms.used+size > ms.limit {
+		return ErrMemoryLimit
+	}
+	ms.used += size
+	return nil
+}
+
+// release removes size from the used bytes
+func (ms *memStore) release(size int64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.used -= size
+}
+
+func (ms *memStore) put(name string, data []byte) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// overwriting (i.e. fin.db) so free the old one
+	if old, ok := ms.blobs[name]; ok {
+		ms.used -= int64(len(old))
+	}
+	ms.blobs[name] = data
+}
+
+func (ms *memStore) create(name string) (destination, error) {
+	return &memWriter{store: ms, name: name}, nil
+}
+
+func (ms *memStore) open(name string) (File, error) {
+	ms.mu.Lock()
+	data, ok := ms.blobs[name]
+	ms.mu.Unlock()
+
+	if ok {
+		return memFile{bytes.NewReader(data)}, nil
+	}
+	if ms.spill != nil {
+		return ms.spill.open(name)
+	}
+	return nil, fmt.Errorf("open %v: %w", name, os.ErrNotExist)
+}
+
+func (ms *memStore) remove(name string) error {
+	ms.mu.Lock()
+	data, ok := ms.blobs[name]
+	if ok {
+		delete(ms.blobs, name)
+		ms.used -= int64(len(data))
+	}
+	ms.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+	if ms.spill != nil {
+		return ms.spill.remove(name)
+	}
+	return fmt.Errorf("remove %v: %w", name, os.ErrNotExist)
+}
+
+// ------------- memStore ------------------
+
+// ------------- memWriter ------------------
+// memWriter buffers a file in memory, moving it to the spill dir if it gets to big
+type memWriter struct {
+	store   *memStore
+	name    string
+	buf     bytes.Buffer
+	spill   destination
+	deleted bool
+}
+
+func (mw *memWriter) Write(p []byte) (int, error) {
+	if mw.spill != nil {
+		return mw.spill.Write(p)
+	}
+
+	err := mw.store.reserve(int64(len(p)))
+	if err == nil {
+		return mw.buf.Write(p)
+	}
+	if mw.store.spill == nil {
+		return 0, err
+	}
+
+	if err := mw.spillToDisk(); err != nil {
+		return 0, err
+	}
+	return mw.spill.Write(p)
+}
+
+// spillToDisk moves what has been written so far to the spill dir
+func (mw *memWriter) spillToDisk() error {
+	if err := os.MkdirAll(mw.store.spill.dir, 0755); err != nil {
+		return fmt.Errorf("unable to create spill dir: %w", err)
+	}
+	spill, err := mw.store.spill.create(mw.name)
+	if err != nil {
+		return err
+	}
+	if _, err := spill.Write(mw.buf.Bytes()); err != nil {
+		spill.Delete()
+		return err
+	}
+
+	mw.store.release(int64(mw.buf.Len()))
+	mw.buf = bytes.Buffer{}
+	mw.spill = spill
+	return nil
+}
+
+func (mw *memWriter) Close() error {
+	if mw.spill != nil {
+		return mw.spill.Close()
+	}
+	if mw.deleted {
+		return nil
+	}
+	mw.store.put(mw.name, mw.buf.Bytes())
+	return nil
+}
+
+func (mw *memWriter) Delete() error {
+	if mw.spill != nil {
+		return mw.spill.Delete()
+	}
+	mw.store.release(int64(mw.buf.Len()))
+	mw.buf = bytes.Buffer{}
+	mw.deleted = true
+	return nil
+}
+
+// ------------- memWriter ------------------
+
+// memFile is a File for a blob in memory
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
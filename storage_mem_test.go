@@ -0,0 +1,95 @@
+package virtualfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func newFooMemFs(opts ...Option) (*Fs, error) {
+	f, err := os.Open(fooFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewMemFs("foo", fooMod, fooTime, f, opts...)
+}
+
+func assertContent(t *testing.T, exp string, v *Fs, path string) {
+	t.Helper()
+
+	file, err := v.OpenReader(path)
+	fatalfIfErr(t, err, "failed to open %v", path)
+	defer file.Close()
+
+	act, err := io.ReadAll(file)
+	fatalfIfErr(t, err, "failed to read %v", path)
+	assertEqual(t, exp, string(act), "content doesnt match for %v", path)
+}
+
+func TestMemFs(t *testing.T) {
+	v, err := newFooMemFs()
+	fatalfIfErr(t, err, "failed to create in memory virtual function")
+
+	err = createFile(v, "/foo1/bar", 0655, time1, "Hello, World!")
+	fatalfIfErr(t, err, "failed to create virtual file /foo1/bar")
+
+	err = createFile(v, "/foo1/baz", 0600, time2, "Hello, World!")
+	fatalfIfErr(t, err, "failed to create virtual file /foo1/baz")
+
+	expected := []fileinfoTest{
+		{"/", fooMod, fooTime, fooSha512, "application/octet-stream", "", emptyTags},
+		{"/foo1", 0655 | fs.ModeDir, time1, "", "directory/directory", "", emptyTags},
+		{"/foo1/bar", 0655, time1, helloWorldSha512, "text/plain; charset=utf-8", "", emptyTags},
+		{"/foo1/baz", 0600, time2, helloWorldSha512, "text/plain; charset=utf-8", "", emptyTags},
+	}
+	assertFiles(t, expected, v, "after creating files in memory")
+	assertContent(t, "Hello, World!", v, "/foo1/baz")
+	assertEqual(t, "", v.FilePath(), "in memory fs shouldnt have a file path")
+	_, err = v.Open("/foo1/baz")
+	assertErr(t, ErrNotOnDisk, err, "in memory file cant be opened as an os.File")
+
+	ms := v.db.store.(*memStore)
+	assertEqual(t, 2, len(ms.blobs), "duplicate should only be stored once")
+
+	err = v.Close()
+	fatalfIfErr(t, err, "failed to close in memory fs")
+	_, ok := ms.blobs[finDB]
+	assert(t, ok, "expected db to be saved in memory")
+}
+
+func TestMemFsLimit(t *testing.T) {
+	v, err := NewMemFs("foo-folder", testMod, testTime, nil, WithMemoryLimit(10))
+	fatalfIfErr(t, err, "failed to create in memory virtual function")
+
+	err = createFile(v, "/bar", 0655, time1, "Hello!")
+	fatalfIfErr(t, err, "failed to create virtual file /bar under the limit")
+
+	err = createFile(v, "/baz", 0655, time1, "Hello, World!")
+	assertErr(t, ErrMemoryLimit, err, "should error when over memory limit")
+}
+
+func TestMemFsSpill(t *testing.T) {
+	tmpDir(t, func(tmp string) {
+		v, err := NewMemFs("foo-folder", testMod, testTime, nil, WithMemoryLimit(10), WithSpillDir(tmp))
+		fatalfIfErr(t, err, "failed to create in memory virtual function")
+
+		err = createFile(v, "/bar", 0655, time1, "Hello!")
+		fatalfIfErr(t, err, "failed to create virtual file /bar under the limit")
+		_, err = os.Stat(tmp)
+		assert(t, os.IsNotExist(err), "shouldnt create spill dir until needed")
+
+		err = createFile(v, "/baz", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /baz over the limit")
+		assertTmpDirFileCount(t, 1, tmp, "after spilling")
+
+		err = createFile(v, "/baz-duplicate", 0655, time1, "Hello, World!")
+		fatalfIfErr(t, err, "failed to create virtual file /baz-duplicate over the limit")
+		assertTmpDirFileCount(t, 1, tmp, "duplicate should be removed from spill dir")
+
+		assertContent(t, "Hello!", v, "/bar")
+		assertContent(t, "Hello, World!", v, "/baz")
+		assertContent(t, "Hello, World!", v, "/baz-duplicate")
+	})
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package virtualfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of the file on disk (nil if none or not supported)
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(path, list)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		size, err := syscall.Getxattr(path, string(name), nil)
+		if errors.Is(err, syscall.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value[:size]
+	}
+	return xattrs, nil
+}
+
+// writeXattrs sets the extended attributes on the file on disk, skipping ones that
+// arent permitted (i.e. security.* if not root) or supported by the filesystem
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		err := syscall.Setxattr(path, name, value, 0)
+		if err != nil && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package virtualfs
+
+// readXattrs isnt supported so none
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs isnt supported so skip
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}